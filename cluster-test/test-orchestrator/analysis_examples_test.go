@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Check that a section scanner get's the stats between the time labels t0 and t1
@@ -25,15 +26,47 @@ func ExampleSectionScanner() {
 
 func ExampleCountAnalysis() {
 	s := bufio.NewScanner(strings.NewReader(stats))
-	c1, _ := CountAnalysis(s, "ping.send")
+	c1, _ := CountAnalysis(s, "ringpop", "ping.send")
 	s = bufio.NewScanner(strings.NewReader(stats))
-	c2, _ := CountAnalysis(s, "changes.disseminate")
+	c2, _ := CountAnalysis(s, "ringpop", "changes.disseminate")
 	fmt.Println(c1, c2)
 
+	s = bufio.NewScanner(strings.NewReader(countStats))
+	c3, _ := CountAnalysis(s, "ringpop", "ping.send")
+	s = bufio.NewScanner(strings.NewReader(countStats))
+	c4, _ := CountAnalysis(s, "ringpop", "ping*.send")
+	s = bufio.NewScanner(strings.NewReader(countStats))
+	c5, _ := CountAnalysis(s, "ringpop", "ringpop.172_18_24_220_3001.*")
+	fmt.Println(c3, c4, c5)
+
+	// Output:
+	// 2 0
+	// 13 15 10
+}
+
+func ExampleLinesAnalysis() {
+	s := bufio.NewScanner(strings.NewReader(stats))
+	l1, _ := LinesAnalysis(s, "ringpop", "ping.send")
+	s = bufio.NewScanner(strings.NewReader(stats))
+	l2, _ := LinesAnalysis(s, "ringpop", "changes.disseminate")
+	s = bufio.NewScanner(strings.NewReader(countStats))
+	l3, _ := LinesAnalysis(s, "ringpop", "ping.send")
+	fmt.Println(l1, l2, l3)
+
 	// Output:
-	// 2 4
+	// 2 4 4
 }
 
+// ping.send is sent 3 + 10 times, the second node samples one in ten
+var countStats = `
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:2|c
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping-req.send:2|c
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3001.ping.send:1|c|@0.1
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3001.changes.disseminate:2|g
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3001.ping.send:5|ms
+`
+
 var stats = `
 2016-06-15T16:11:08.198146603Z|ringpop.172_18_24_220_3007.protocol.delay:200|ms
 2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3007.changes.disseminate:0|g
@@ -54,9 +87,9 @@ label:t1|cmd: wait-for-stable
 2016-06-15T16:11:08.254576313Z|ringpop.172_18_24_220_3003.protocol.delay:200|ms
 `
 
-func ExampleChecksumAnalysis() {
+func ExampleChecksumsAnalysis() {
 	s := bufio.NewScanner(strings.NewReader(csumStats))
-	csums, _ := ChecksumsAnalysis(s)
+	csums, _ := ChecksumsAnalysis(s, "ringpop")
 	fmt.Println(csums)
 
 	// Output:
@@ -75,7 +108,7 @@ var csumStats = `
 
 func ExampleConvergenceTimeAnalysis() {
 	s := bufio.NewScanner(strings.NewReader(convtimeStats))
-	convtime, _ := ConvergenceTimeAnalysis(s)
+	convtime, _ := ConvergenceTimeAnalysis(s, "ringpop")
 	fmt.Println(convtime)
 
 	// Output:
@@ -84,21 +117,99 @@ func ExampleConvergenceTimeAnalysis() {
 
 // time between the first and last recoreded change is 8 seconds
 var convtimeStats = `
-2016-06-17T11:29:15.0Z|ringpop.172_18_24_192_3000.noise
-2016-06-17T11:29:16.0Z|ringpop.172_18_24_192_3000.noise
-2016-06-17T11:29:17.0Z|ringpop.172_18_24_192_3000.noise
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_192_3000.noise:1|c
+2016-06-17T11:29:16.0Z|ringpop.172_18_24_192_3000.noise:1|c
+2016-06-17T11:29:17.0Z|ringpop.172_18_24_192_3000.noise:1|c
 2016-06-17T11:29:18.0Z|ringpop.172_18_24_192_3000.membership-set.suspect:1|c
 2016-06-17T11:29:19.0Z|ringpop.172_18_24_192_3001.membership-set.suspect:1|c
 2016-06-17T11:29:20.0Z|ringpop.172_18_24_192_3002.membership-set.suspect:1|c
 2016-06-17T11:29:21.0Z|ringpop.172_18_24_192_3002.membership-set.suspect:1|c
-2016-06-17T11:29:21.0Z|ringpop.172_18_24_192_3000.noise
-2016-06-17T11:29:21.0Z|ringpop.172_18_24_192_3000.noise
+2016-06-17T11:29:21.0Z|ringpop.172_18_24_192_3000.noise:1|c
+2016-06-17T11:29:21.0Z|ringpop.172_18_24_192_3000.noise:1|c
 2016-06-17T11:29:22.0Z|ringpop.172_18_24_192_3003.membership-set.suspect:1|c
 2016-06-17T11:29:23.0Z|ringpop.172_18_24_192_3003.membership-set.suspect:1|c
 2016-06-17T11:29:24.0Z|ringpop.172_18_24_192_3003.membership-set.suspect:1|c
 2016-06-17T11:29:25.0Z|ringpop.172_18_24_192_3004.membership-set.suspect:1|c
 2016-06-17T11:29:26.0Z|ringpop.172_18_24_192_3005.membership-set.suspect:1|c
-2016-06-17T11:29:27.0Z|ringpop.172_18_24_192_3000.noise
-2016-06-17T11:29:28.0Z|ringpop.172_18_24_192_3000.noise
-2016-06-17T11:29:29.0Z|ringpop.172_18_24_192_3000.noise
+2016-06-17T11:29:27.0Z|ringpop.172_18_24_192_3000.noise:1|c
+2016-06-17T11:29:28.0Z|ringpop.172_18_24_192_3000.noise:1|c
+2016-06-17T11:29:29.0Z|ringpop.172_18_24_192_3000.noise:1|c
+`
+
+func ExampleSilentNodesAnalysis() {
+	s := bufio.NewScanner(strings.NewReader(silentStats))
+	silent, _ := SilentNodesAnalysis(s, "ringpop", time.Second)
+	fmt.Println(silent)
+
+	// Output:
+	// 2
+}
+
+// The measurements only analyze the stats of the nodes with the configured
+// prefix.
+func ExampleMeasurement_Measure_statsPrefix() {
+	config := defaultConfig()
+	for _, prefix := range []string{"myapp", "ringpop"} {
+		config.StatsPrefix = prefix
+		for _, str := range []string{".. .. count ping.send", ".. .. lines ping.send", ".. .. checksums", ".. .. convtime"} {
+			m, _ := parseMeasurement(str)
+			v, err := m.Measure(bufio.NewScanner(strings.NewReader(prefixStats)), config)
+			fmt.Println(prefix, str, v, err)
+		}
+	}
+
+	// Output:
+	// myapp .. .. count ping.send 2 <nil>
+	// myapp .. .. lines ping.send 2 <nil>
+	// myapp .. .. checksums 2 <nil>
+	// myapp .. .. convtime 1.5s <nil>
+	// ringpop .. .. count ping.send 1 <nil>
+	// ringpop .. .. lines ping.send 1 <nil>
+	// ringpop .. .. checksums 1 <nil>
+	// ringpop .. .. convtime 0s <nil>
+}
+
+var prefixStats = `
+2016-06-17T11:29:08.0Z|myapp.172_18_24_220_3000.ping.send:1|c
+2016-06-17T11:29:08.0Z|myapp.172_18_24_220_3001.ping.send:1|c
+2016-06-17T11:29:08.0Z|ringpop.172_18_24_220_3000.ping.send:1|c
+2016-06-17T11:29:08.0Z|myapp.172_18_24_220_3000.checksum:1111|g
+2016-06-17T11:29:08.0Z|myapp.172_18_24_220_3001.checksum:2222|g
+2016-06-17T11:29:08.0Z|ringpop.172_18_24_220_3000.checksum:1111|g
+2016-06-17T11:29:08.0Z|myapp.172_18_24_220_3000.membership-set.alive:1|c
+2016-06-17T11:29:09.5Z|myapp.172_18_24_220_3001.membership-set.alive:1|c
+2016-06-17T11:29:12.0Z|ringpop.172_18_24_220_3001.membership-set.alive:1|c
+`
+
+// Without an argument the silent-nodes measurement uses the silent-after
+// setting.
+func ExampleMeasurement_Measure_silentNodes() {
+	config := defaultConfig()
+	for _, str := range []string{".. .. silent-nodes", ".. .. silent-nodes 500ms"} {
+		for _, silentAfter := range []time.Duration{5 * time.Second, time.Second, 0} {
+			m, _ := parseMeasurement(str)
+			config.SilentAfter = silentAfter
+			fmt.Println(m.Measure(bufio.NewScanner(strings.NewReader(silentStats)), config))
+		}
+	}
+
+	// Output:
+	// 0 <nil>
+	// 2 <nil>
+	// <nil> silent-nodes expects a duration argument when silent-after is 0, has []
+	// 2 <nil>
+	// 2 <nil>
+	// 2 <nil>
+}
+
+// node 3001 and 3002 stopped emitting stats more than a second before the end
+var silentStats = `
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_192_3000.changes.disseminate:0|g
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_192_3001.changes.disseminate:0|g
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_192_3002.changes.disseminate:0|g
+label:t1|cmd: kill 2
+2016-06-17T11:29:16.0Z|ringpop.172_18_24_192_3000.changes.disseminate:0|g
+2016-06-17T11:29:16.0Z|ringpop.172_18_24_192_3001.changes.disseminate:0|g
+2016-06-17T11:29:17.0Z|ringpop.172_18_24_192_3000.changes.disseminate:0|g
+2016-06-17T11:29:17.5Z|ringpop.172_18_24_192_3000.changes.disseminate:0|g
 `
@@ -25,23 +25,36 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"strings"
 	"time"
 )
 
-// An Assertion checks if a Value is equal or is contained by an interval.
+// An Assertion checks if a Value is equal to, compares to or is contained by
+// an interval of the Values of the assertion.
 type Assertion struct {
-	Type AssertionType // can be is or in
+	Type AssertionType
 
-	// This is the Value of the Assertion in case of AssertionTypeIs
-	// or the first Value of the interval in case of AssertionTypeIn.
+	// This is the Value of the Assertion in case of AssertionTypeIs and the
+	// comparisons, the first Value of the interval in case of AssertionTypeIn
+	// and the Value that is approximated in case of AssertionTypeApprox and
+	// AssertionTypeWithin.
 	V1 Value
 
-	// The second Value of the interval in case of AssertionTypeIn.
-	// This value is ignored in case of AssertionTypeIs.
+	// The second Value of the interval in case of AssertionTypeIn, the
+	// tolerance in case of AssertionTypeApprox and the percentage as written
+	// in case of AssertionTypeWithin, e.g. 10 for within 10%. This value is
+	// ignored otherwise.
+	//
+	// One of the Values of an interval may be nil for an interval that is
+	// unbounded on that side, e.g. in (1s,).
 	V2 Value
+
+	// The assertions that are combined by AssertionTypeAnd,
+	// AssertionTypeOr and AssertionTypeNot, which has a single operand.
+	Operands []*Assertion
 }
 
-// AssertionType is the type (in or is) of an Assertion
+// AssertionType is the type of an Assertion.
 type AssertionType string
 
 const (
@@ -49,8 +62,29 @@ const (
 	AssertionTypeIs AssertionType = "is"
 
 	// AssertionTypeIn is the type that is used to check a value is contained
-	// by an interval.
+	// by an interval. The bounds are part of the interval.
 	AssertionTypeIn AssertionType = "in"
+
+	// The types that compare the value to the Value of the Assertion.
+	AssertionTypeLess         AssertionType = "<"
+	AssertionTypeLessEqual    AssertionType = "<="
+	AssertionTypeGreater      AssertionType = ">"
+	AssertionTypeGreaterEqual AssertionType = ">="
+	AssertionTypeNotEqual     AssertionType = "!="
+
+	// AssertionTypeApprox is the type that is used to check a value differs
+	// at most a tolerance from the Value of the Assertion, e.g. ≈ 10 ± 2.
+	AssertionTypeApprox AssertionType = "≈"
+
+	// AssertionTypeWithin is the type that is used to check a value differs
+	// at most a percentage from the Value of the Assertion, e.g. within 10%
+	// of 2s.
+	AssertionTypeWithin AssertionType = "within"
+
+	// The types that combine assertions, e.g. in (1,3) and not is 2.
+	AssertionTypeAnd AssertionType = "and"
+	AssertionTypeOr  AssertionType = "or"
+	AssertionTypeNot AssertionType = "not"
 )
 
 // String converts an assertion to its string representation. Some examples:
@@ -58,39 +92,161 @@ const (
 // - is 4
 // - in (90, 110)
 // - in (1s, 2s)
+// - in (1s,)
+// - <= 5
+// - ≈ 10 ± 2
+// - within 10% of 2s
+// - is 1 or (> 5 and not is 10)
 func (a *Assertion) String() string {
 	if a == nil {
 		return ""
 	}
-	if a.Type == AssertionTypeIs {
-		return fmt.Sprintf("is %v", a.V1)
-	}
-	if a.Type == AssertionTypeIn {
-		return fmt.Sprintf("in (%v,%v)", a.V1, a.V2)
+	switch a.Type {
+	case AssertionTypeAnd, AssertionTypeOr:
+		strs := make([]string, len(a.Operands))
+		for i, op := range a.Operands {
+			strs[i] = op.operandString()
+		}
+		return strings.Join(strs, " "+string(a.Type)+" ")
+	case AssertionTypeNot:
+		return "not " + a.Operands[0].operandString()
+	case AssertionTypeIs, AssertionTypeLess, AssertionTypeLessEqual,
+		AssertionTypeGreater, AssertionTypeGreaterEqual, AssertionTypeNotEqual:
+		return fmt.Sprintf("%s %v", a.Type, a.V1)
+	case AssertionTypeIn:
+		return fmt.Sprintf("in (%s,%s)", boundString(a.V1), boundString(a.V2))
+	case AssertionTypeApprox:
+		return fmt.Sprintf("≈ %v ± %v", a.V1, a.V2)
+	case AssertionTypeWithin:
+		return fmt.Sprintf("within %v%% of %v", a.V2, a.V1)
 	}
 
 	log.Fatalf("Unknown assertion %s", a.Type)
 	return ""
 }
 
+// operandString returns the string of an assertion that is combined with
+// other assertions, the combinations of assertions are enclosed by
+// parenthesis.
+func (a *Assertion) operandString() string {
+	if a.Type == AssertionTypeAnd || a.Type == AssertionTypeOr {
+		return "(" + a.String() + ")"
+	}
+	return a.String()
+}
+
+// boundString returns the string of a bound of an interval, which is empty
+// for an unbounded side.
+func boundString(v Value) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
 // Assert makes the assertion. Returns an error if the assertion failed.
 func (a *Assertion) Assert(v Value) error {
 	if a == nil {
 		return nil
 	}
 
+	if nodes, ok := v.(NodeValues); ok {
+		return a.assertNodes(nodes)
+	}
+	if isBaselineExpr(a.V1) || isBaselineExpr(a.V2) {
+		msg := fmt.Sprintf("FAILED assertion: %s compares with a baseline that isn't known", a)
+		return errors.New(msg)
+	}
+
+	// zero doesn't require a unit when it is compared with a duration
+	V1 := zeroAsDuration(a.V1, v)
+	V2 := zeroAsDuration(a.V2, v)
+
 	switch a.Type {
 	case AssertionTypeIs:
-		return equalsAssert(v, a.V1)
+		return equalsAssert(v, V1)
 	case AssertionTypeIn:
-		return rangeAssert(v, a.V1, a.V2)
+		return rangeAssert(v, V1, V2)
+	case AssertionTypeLess, AssertionTypeLessEqual, AssertionTypeGreater,
+		AssertionTypeGreaterEqual, AssertionTypeNotEqual:
+		return compareAssert(a.Type, v, V1)
+	case AssertionTypeApprox:
+		return approxAssert(v, V1, V2)
+	case AssertionTypeWithin:
+		return withinAssert(v, V1, a.V2)
+	case AssertionTypeAnd:
+		return a.andAssert(v)
+	case AssertionTypeOr:
+		return a.orAssert(v)
+	case AssertionTypeNot:
+		if a.Operands[0].Assert(v) == nil {
+			msg := fmt.Sprintf("FAILED assertion: expected %s got %v", a, v)
+			return errors.New(msg)
+		}
+		return nil
 	}
 
-	msg := fmt.Sprintf("FAILED assertion: type must be 'in' or 'is' but is %v", a.Type)
+	msg := fmt.Sprintf("FAILED assertion: unknown assertion type %v", a.Type)
+	return errors.New(msg)
+}
+
+// comparedValues returns the Values of the Assertion that are compared with
+// the measured value, these must have the type of the measured value.
+func (a *Assertion) comparedValues() []Value {
+	var vs []Value
+	switch a.Type {
+	case AssertionTypeAnd, AssertionTypeOr, AssertionTypeNot:
+		for _, op := range a.Operands {
+			vs = append(vs, op.comparedValues()...)
+		}
+	case AssertionTypeIn, AssertionTypeApprox:
+		vs = []Value{a.V1, a.V2}
+	default:
+		vs = []Value{a.V1}
+	}
+
+	var result []Value
+	for _, v := range vs {
+		if v != nil {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// andAssert checks that every operand holds and returns an error that shows
+// the first branch that failed otherwise.
+func (a *Assertion) andAssert(v Value) error {
+	for _, op := range a.Operands {
+		if err := op.Assert(v); err != nil {
+			msg := fmt.Sprintf("FAILED assertion: branch '%s' of '%s' failed for %v: %s", op, a, v, failure(err))
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// orAssert checks that at least one of the operands holds and returns an
+// error that shows why every branch failed otherwise.
+func (a *Assertion) orAssert(v Value) error {
+	var failures []string
+	for _, op := range a.Operands {
+		err := op.Assert(v)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("'%s': %s", op, failure(err)))
+	}
+	msg := fmt.Sprintf("FAILED assertion: no branch of '%s' holds for %v: %s", a, v, strings.Join(failures, "; "))
 	return errors.New(msg)
 }
 
-// isAssert checks if the Values are equal and returns an error otherwise.
+// failure returns the message of a failed assertion without its prefix.
+func failure(err error) string {
+	return strings.TrimPrefix(err.Error(), "FAILED assertion: ")
+}
+
+// equalsAssert checks if the Values are equal and returns an error otherwise.
 func equalsAssert(v, V1 Value) error {
 	if reflect.DeepEqual(v, V1) {
 		return nil
@@ -100,31 +256,104 @@ func equalsAssert(v, V1 Value) error {
 	return errors.New(msg)
 }
 
-// inAssert checks if the Value is contained by the interval (V1, V2) and
-// returns an error otherwise.
+// rangeAssert checks if the Value is contained by the interval (V1, V2) and
+// returns an error otherwise. A nil bound leaves the interval unbounded on
+// that side.
 func rangeAssert(v, V1, V2 Value) error {
 	// check if types match
 	tv := reflect.TypeOf(v)
 	tv1 := reflect.TypeOf(V1)
 	tv2 := reflect.TypeOf(V2)
-	if tv != tv1 || tv != tv2 {
-		msg := fmt.Sprintf("FAILED assertion: type mismatch %v (%v,%v)", v, V1, V2)
+	if V1 != nil && tv != tv1 || V2 != nil && tv != tv2 {
+		msg := fmt.Sprintf("FAILED assertion: type mismatch %v (%s,%s)", v, boundString(V1), boundString(V2))
 		return errors.New(msg)
 	}
 
 	// convert to float for easy comparison
 	f := toFloat64(v)
-	f1 := toFloat64(V1)
-	f2 := toFloat64(V2)
+	if V1 != nil && f < toFloat64(V1) || V2 != nil && toFloat64(V2) < f {
+		msg := fmt.Sprintf("FAILED assertion: %v not in (%s,%s)", v, boundString(V1), boundString(V2))
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// compareAssert checks if the Value compares to V1 as the comparison of the
+// type and returns an error otherwise.
+func compareAssert(typ AssertionType, v, V1 Value) error {
+	if reflect.TypeOf(v) != reflect.TypeOf(V1) {
+		msg := fmt.Sprintf("FAILED assertion: type mismatch %v %s %v", v, typ, V1)
+		return errors.New(msg)
+	}
+
+	f, f1 := toFloat64(v), toFloat64(V1)
+	var holds bool
+	switch typ {
+	case AssertionTypeLess:
+		holds = f < f1
+	case AssertionTypeLessEqual:
+		holds = f <= f1
+	case AssertionTypeGreater:
+		holds = f > f1
+	case AssertionTypeGreaterEqual:
+		holds = f >= f1
+	case AssertionTypeNotEqual:
+		holds = f != f1
+	}
+	if holds {
+		return nil
+	}
+
+	msg := fmt.Sprintf("FAILED assertion: expected %s %v got %v", typ, V1, v)
+	return errors.New(msg)
+}
 
-	if f < f1 || f2 < f {
-		msg := fmt.Sprintf("FAILED assertion: %v not in (%v,%v)", v, V1, V2)
+// approxAssert checks if the Value differs at most the tolerance from V1 and
+// returns an error otherwise.
+func approxAssert(v, V1, tolerance Value) error {
+	if reflect.TypeOf(v) != reflect.TypeOf(V1) || reflect.TypeOf(v) != reflect.TypeOf(tolerance) {
+		msg := fmt.Sprintf("FAILED assertion: type mismatch %v ≈ %v ± %v", v, V1, tolerance)
 		return errors.New(msg)
 	}
 
+	f, f1 := toFloat64(v), toFloat64(V1)
+	if f < f1-toFloat64(tolerance) || f1+toFloat64(tolerance) < f {
+		msg := fmt.Sprintf("FAILED assertion: expected ≈ %v ± %v got %v", V1, tolerance, v)
+		return errors.New(msg)
+	}
 	return nil
 }
 
+// withinAssert checks if the Value differs at most the percentage of V1 from
+// V1 and returns an error otherwise.
+func withinAssert(v, V1, percentage Value) error {
+	if reflect.TypeOf(v) != reflect.TypeOf(V1) {
+		msg := fmt.Sprintf("FAILED assertion: type mismatch %v within %v%% of %v", v, percentage, V1)
+		return errors.New(msg)
+	}
+
+	f, f1 := toFloat64(v), toFloat64(V1)
+	tolerance := toFloat64(percentage) / 100 * f1
+	if tolerance < 0 {
+		tolerance = -tolerance
+	}
+	if f < f1-tolerance || f1+tolerance < f {
+		msg := fmt.Sprintf("FAILED assertion: expected within %v%% of %v got %v", percentage, V1, v)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// zeroAsDuration returns v as a duration when v is the number zero and other
+// is a duration.
+func zeroAsDuration(v, other Value) Value {
+	if _, ok := other.(time.Duration); ok && v == Value(0.0) {
+		return time.Duration(0)
+	}
+	return v
+}
+
 // toFloat64 converts a value into a float64. Even is the value is a duration
 // because time.Duration is a uint64 which we can convert to a float64.
 func toFloat64(v Value) float64 {
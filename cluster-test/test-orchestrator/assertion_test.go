@@ -5,23 +5,22 @@ import (
 	"time"
 )
 
-func ExampleIsAssertion() {
-	a := &Assertion{AssertionTypeIs, 2 * time.Second, nil}
+func ExampleAssertion_Assert_is() {
+	a := &Assertion{Type: AssertionTypeIs, V1: 2 * time.Second}
 	fmt.Println(a)
 	fmt.Println(a.Assert(2 * time.Second))
 	fmt.Println(a.Assert(0 * time.Second).Error())
 	fmt.Println(a.Assert(3 * time.Second).Error())
-	fmt.Println()
 
 	// Output:
 	// is 2s
 	// <nil>
-	// FAILED assertion: expected 2s got 0
+	// FAILED assertion: expected 2s got 0s
 	// FAILED assertion: expected 2s got 3s
 }
 
-func ExampleInAssertion() {
-	a := &Assertion{AssertionTypeIn, 1.0, 3.0}
+func ExampleAssertion_Assert_in() {
+	a := &Assertion{Type: AssertionTypeIn, V1: 1.0, V2: 3.0}
 	fmt.Println(a)
 	fmt.Println(a.Assert(0.0))
 	fmt.Println(a.Assert(1.0))
@@ -30,6 +29,12 @@ func ExampleInAssertion() {
 	fmt.Println(a.Assert(4.0))
 	fmt.Println(a.Assert(2 * time.Second))
 
+	a = &Assertion{Type: AssertionTypeIn, V1: time.Second}
+	fmt.Println(a)
+	fmt.Println(a.Assert(time.Hour))
+	fmt.Println(a.Assert(time.Millisecond))
+
+	// Output:
 	// in (1,3)
 	// FAILED assertion: 0 not in (1,3)
 	// <nil>
@@ -37,4 +42,78 @@ func ExampleInAssertion() {
 	// <nil>
 	// FAILED assertion: 4 not in (1,3)
 	// FAILED assertion: type mismatch 2s (1,3)
+	// in (1s,)
+	// <nil>
+	// FAILED assertion: 1ms not in (1s,)
+}
+
+func ExampleAssertion_Assert_compare() {
+	for _, str := range []string{"< 5", "<= 5", "> 5", ">= 5", "!= 5"} {
+		a, _ := parseAssertion(str[:len(str)-2], "5")
+		fmt.Println(a, a.Assert(4.0), a.Assert(5.0), a.Assert(6.0))
+	}
+
+	a, _ := parseAssertion(">=", "0")
+	fmt.Println(a.Assert(time.Second))
+	fmt.Println(a.Assert(-time.Second))
+	fmt.Println(a.Assert(true))
+
+	// Output:
+	// < 5 <nil> FAILED assertion: expected < 5 got 5 FAILED assertion: expected < 5 got 6
+	// <= 5 <nil> <nil> FAILED assertion: expected <= 5 got 6
+	// > 5 FAILED assertion: expected > 5 got 4 FAILED assertion: expected > 5 got 5 <nil>
+	// >= 5 FAILED assertion: expected >= 5 got 4 <nil> <nil>
+	// != 5 <nil> FAILED assertion: expected != 5 got 5 <nil>
+	// <nil>
+	// FAILED assertion: expected >= 0s got -1s
+	// FAILED assertion: type mismatch true >= 0
+}
+
+func ExampleAssertion_Assert_tolerance() {
+	a, _ := parseAssertion("≈", "10 ± 2")
+	fmt.Println(a, a.Assert(8.0), a.Assert(12.5))
+
+	a, _ = parseAssertion("~=", "1s +- 100ms")
+	fmt.Println(a, a.Assert(900*time.Millisecond), a.Assert(2.0))
+
+	a, _ = parseAssertion("within", "10% of 2s")
+	fmt.Println(a, a.Assert(2200*time.Millisecond), a.Assert(time.Second))
+
+	a, _ = parseAssertion("within", "7% of 100")
+	fmt.Println(a, a.Assert(107.0), a.Assert(108.0))
+
+	_, err := parseAssertion("≈", "10")
+	fmt.Println(err)
+	_, err = parseAssertion("within", "10 of 2s")
+	fmt.Println(err)
+
+	// Output:
+	// ≈ 10 ± 2 <nil> FAILED assertion: expected ≈ 10 ± 2 got 12.5
+	// ≈ 1s ± 100ms <nil> FAILED assertion: type mismatch 2 ≈ 1s ± 100ms
+	// within 10% of 2s <nil> FAILED assertion: expected within 10% of 2s got 1s
+	// within 7% of 100 <nil> FAILED assertion: expected within 7% of 100 got 108
+	// assertion '≈ 10': approximation should be of the form ≈ x ± y
+	// assertion 'within 10 of 2s': should be of the form within x% of y
+}
+
+func ExampleAssertion_Assert_composite() {
+	a, _ := parseAssertions("in (3, 9) and not 5")
+	fmt.Println(a)
+	fmt.Println(a.Assert(4.0))
+	fmt.Println(a.Assert(5.0))
+	fmt.Println(a.Assert(10.0))
+
+	a, _ = parseAssertions("is 1 or (> 5 and < 10)")
+	fmt.Println(a)
+	fmt.Println(a.Assert(1.0))
+	fmt.Println(a.Assert(12.0))
+
+	// Output:
+	// in (3,9) and not is 5
+	// <nil>
+	// FAILED assertion: branch 'not is 5' of 'in (3,9) and not is 5' failed for 5: expected not is 5 got 5
+	// FAILED assertion: branch 'in (3,9)' of 'in (3,9) and not is 5' failed for 10: 10 not in (3,9)
+	// is 1 or (> 5 and < 10)
+	// <nil>
+	// FAILED assertion: no branch of 'is 1 or (> 5 and < 10)' holds for 12: 'is 1': expected 1 got 12; '> 5 and < 10': branch '< 10' of '> 5 and < 10' failed for 12: expected < 10 got 12
 }
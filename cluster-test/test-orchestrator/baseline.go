@@ -0,0 +1,241 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Baseline holds the Values measured in an earlier run, e.g. of the last
+// release, so that assertions can compare against them instead of against
+// absolute bounds. The Values are keyed by the scenario and its run variables
+// and by the measurement.
+type Baseline struct {
+	values map[string]map[string]Value
+}
+
+// NewBaseline returns an empty Baseline.
+func NewBaseline() *Baseline {
+	return &Baseline{values: make(map[string]map[string]Value)}
+}
+
+// LoadBaseline reads a Baseline from a yaml file. A file that doesn't exist
+// results in an empty Baseline.
+func LoadBaseline(path string) (*Baseline, error) {
+	b := NewBaseline()
+	bts, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read baseline")
+	}
+
+	var data map[string]map[string]string
+	if err := yaml.Unmarshal(bts, &data); err != nil {
+		return nil, errors.Wrapf(err, "parse baseline %s", path)
+	}
+	for scn, measurements := range data {
+		b.values[scn] = make(map[string]Value, len(measurements))
+		for m, str := range measurements {
+			v, err := parseValue(str)
+			if err != nil {
+				return nil, errors.Wrapf(err, "baseline %s of %s in %s", m, scn, path)
+			}
+			b.values[scn][m] = v
+		}
+	}
+	return b, nil
+}
+
+// Save writes the Baseline to a yaml file.
+func (b *Baseline) Save(path string) error {
+	// numbers are written as numbers and durations as strings, e.g. 1.5s
+	data := make(map[string]map[string]interface{}, len(b.values))
+	for scn, measurements := range b.values {
+		data[scn] = make(map[string]interface{}, len(measurements))
+		for m, v := range measurements {
+			if d, ok := v.(time.Duration); ok {
+				data[scn][m] = d.String()
+			} else {
+				data[scn][m] = v
+			}
+		}
+	}
+
+	bts, err := yaml.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "marshal baseline")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, bts, 0644), "write baseline")
+}
+
+// Value returns the baseline of a measurement of a scenario or nil when
+// there is none.
+func (b *Baseline) Value(scn *Scenario, m *Measurement) Value {
+	if b == nil {
+		return nil
+	}
+	return b.values[scenarioKey(scn)][measurementKey(m)]
+}
+
+// Update replaces the baseline of the measurements of a scenario by the
+// Values of the results. Measurements that failed to produce a Value keep
+// their baseline.
+func (b *Baseline) Update(scn *Scenario, results []*Result) {
+	key := scenarioKey(scn)
+	for _, r := range results {
+		// the Values of the nodes of a per-node measurement are only kept
+		// when they are aggregated
+		if _, ok := r.Value.(NodeValues); ok || r.Value == nil {
+			continue
+		}
+		if b.values[key] == nil {
+			b.values[key] = make(map[string]Value)
+		}
+		b.values[key][measurementKey(r.Measurement)] = r.Value
+	}
+}
+
+// scenarioKey identifies a run of a scenario in the Baseline by the name of
+// the scenario and the values of its run variables, e.g. "kill N=3 DROP=10%".
+func scenarioKey(scn *Scenario) string {
+	parts := []string{scn.Name}
+	for name, value := range scn.Vars {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, " ")
+}
+
+// measurementKey identifies a measurement of a scenario in the Baseline by
+// everything but its assertion, e.g. "t0 t1 count ping.send".
+func measurementKey(m *Measurement) string {
+	return strings.Join([]string{m.Start, m.End, m.quantityString()}, " ")
+}
+
+// baselineRegex matches the baseline in an expression.
+var baselineRegex = regexp.MustCompile(`\bbaseline\b`)
+
+// BaselineExpr is a Value of an assertion that is an expression of the
+// baseline, e.g. "baseline + 2". It is evaluated against the baseline of the
+// measurement before the assertion is checked.
+type BaselineExpr string
+
+// parseBaselineExpr parses an expression of the baseline. As the type of the
+// baseline isn't known yet, the expression must be valid for a number or a
+// duration.
+func parseBaselineExpr(str string) (BaselineExpr, error) {
+	e := BaselineExpr(strings.TrimSpace(str))
+	_, err := e.evaluate(1.0)
+	if err != nil {
+		if _, durationErr := e.evaluate(time.Second); durationErr == nil {
+			err = nil
+		}
+	}
+	return e, err
+}
+
+// evaluate returns the Value of the expression for the given baseline.
+func (e BaselineExpr) evaluate(baseline Value) (Value, error) {
+	v, err := Evaluate(string(e), map[string]interface{}{"baseline": baseline})
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case float64, time.Duration:
+		return v, nil
+	}
+	msg := fmt.Sprintf("value '%s' is not a number or duration", e)
+	return nil, errors.New(msg)
+}
+
+// isBaselineExpr returns whether a Value is an expression of the baseline.
+func isBaselineExpr(v Value) bool {
+	_, ok := v.(BaselineExpr)
+	return ok
+}
+
+// usesBaseline returns whether the Assertion compares with the baseline.
+func (a *Assertion) usesBaseline() bool {
+	if a == nil {
+		return false
+	}
+	for _, op := range a.Operands {
+		if op.usesBaseline() {
+			return true
+		}
+	}
+	return isBaselineExpr(a.V1) || isBaselineExpr(a.V2)
+}
+
+// WithBaseline returns the Assertion with the expressions of the baseline
+// replaced by their Values for the given baseline. An Assertion that doesn't
+// compare with the baseline is returned as is.
+func (a *Assertion) WithBaseline(baseline Value) (*Assertion, error) {
+	if !a.usesBaseline() {
+		return a, nil
+	}
+	if baseline == nil {
+		msg := fmt.Sprintf("no baseline to compare with for '%s'", a)
+		return nil, errors.New(msg)
+	}
+
+	resolved := *a
+	var err error
+	if resolved.V1, err = resolveBaseline(a.V1, baseline); err != nil {
+		return nil, err
+	}
+	if resolved.V2, err = resolveBaseline(a.V2, baseline); err != nil {
+		return nil, err
+	}
+	resolved.Operands = make([]*Assertion, len(a.Operands))
+	for i, op := range a.Operands {
+		if resolved.Operands[i], err = op.WithBaseline(baseline); err != nil {
+			return nil, err
+		}
+	}
+	return &resolved, nil
+}
+
+// resolveBaseline returns the Value of v for the given baseline when v is an
+// expression of the baseline and v itself otherwise.
+func resolveBaseline(v, baseline Value) (Value, error) {
+	e, ok := v.(BaselineExpr)
+	if !ok {
+		return v, nil
+	}
+	resolved, err := e.evaluate(baseline)
+	if err != nil {
+		return nil, errors.Wrapf(err, "baseline %v", baseline)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func Example_baseline() {
+	dir, err := ioutil.TempDir("", "baseline")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	statsPath := filepath.Join(dir, "stats")
+	baselinePath := filepath.Join(dir, "baseline.yaml")
+	ioutil.WriteFile(statsPath, []byte(iterationStats), 0644)
+
+	scns, err := parse([]byte(baselineTestYaml))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scn := scns[0]
+
+	// without a baseline the assertions fail, but the values are measured
+	baseline, _ := LoadBaseline(baselinePath)
+	var results []*Result
+	for _, m := range scn.Measure {
+		r := measure(m, defaultConfig(), statsPath, baseline.Value(scn, m))
+		fmt.Println(r)
+		results = append(results, r)
+	}
+
+	baseline.Update(scn, results)
+	baseline.Save(baselinePath)
+	bts, _ := ioutil.ReadFile(baselinePath)
+	fmt.Print(string(bts))
+
+	baseline, _ = LoadBaseline(baselinePath)
+	for _, m := range scn.Measure {
+		fmt.Println(measure(m, defaultConfig(), statsPath, baseline.Value(scn, m)))
+	}
+
+	// Output:
+	// FAIL t0 cycle.2.kill count ping.send within 10% of baseline: no baseline to compare with for 'within 10% of baseline'
+	// FAIL cycle.2.kill cycle.2.start count ping.send <= baseline - 1: no baseline to compare with for '<= baseline - 1'
+	// PASS cycle.*.kill cycle.*.start count ping.send in (1,3): mean 2, min 1, max 3
+	//   PASS cycle.1.kill cycle.1.start count ping.send in (1,3): 1
+	//   PASS cycle.2.kill cycle.2.start count ping.send in (1,3): 3
+	// repeat N=2:
+	//     cycle.*.kill cycle.*.start count ping.send: 2
+	//     cycle.2.kill cycle.2.start count ping.send: 3
+	//     t0 cycle.2.kill count ping.send: 3
+	// PASS t0 cycle.2.kill count ping.send within 10% of baseline: 3 (baseline 3)
+	// FAIL cycle.2.kill cycle.2.start count ping.send <= baseline - 1: FAILED assertion: expected <= 2 got 3 (baseline 3)
+	// PASS cycle.*.kill cycle.*.start count ping.send in (1,3): mean 2, min 1, max 3
+	//   PASS cycle.1.kill cycle.1.start count ping.send in (1,3): 1
+	//   PASS cycle.2.kill cycle.2.start count ping.send in (1,3): 3
+}
+
+var baselineTestYaml = `
+scenarios:
+- name: repeat
+  size: 2
+  script:
+  - t0: cluster-start
+  - cycle:
+      repeat: <N>
+      script:
+      - kill: kill 1
+      - start: start 1
+  measure:
+  - t0 cycle.2.kill count ping.send within 10% of baseline
+  - cycle.2.kill cycle.2.start count ping.send <= baseline - 1
+  - cycle.*.kill cycle.*.start count ping.send in (1, 3)
+  runs:
+  - [<N>]
+  - [2]
+`
@@ -22,6 +22,16 @@
 // parsing the tests because when for example we want to assert that the
 // number of suspect declarations in a split brane is equal to
 // "N/2 * N/2 * 2" where N is the cluster size.
+//
+// Besides the arithmetic operators the expressions support the modulo
+// operator, unary minus, comparisons, the logical operators and the functions
+// min, max, ceil, floor, round, log, log2, pow and sqrt, e.g.
+// "max(1, ceil(log2(N))*3)". The result of a comparison is a bool.
+//
+// Duration literals like "200ms" or "1m30s" are durations, a distinct type.
+// Durations can be added to and subtracted from durations, multiplied and
+// divided by numbers and divided by durations, e.g. "N*200ms + 1s". Other
+// mixes of durations and numbers, like "1s + 1", are rejected.
 
 package main
 
@@ -29,70 +39,401 @@ import (
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/token"
+	"math"
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 // Eval evaluates the value of an expression to a float64. It can be used
 // as a simple calculator. e.g: `"2+3*4" -> 14.0`.
-func Eval(expression string) (f float64, err error) {
-	// recover from panic and change the err return value
-	defer func() {
-		if r := recover(); r != nil {
-			err = errors.New(fmt.Sprint(r))
+func Eval(expression string) (float64, error) {
+	v, err := Evaluate(expression, nil)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		msg := fmt.Sprintf("expression \"%s\" is not a number", expression)
+		return 0, errors.New(msg)
+	}
+	return f, nil
+}
+
+// Evaluate evaluates an expression in which identifiers are looked up in
+// vars. The result, like the variables, is either a float64, a time.Duration
+// or a bool.
+func Evaluate(expression string, vars map[string]interface{}) (interface{}, error) {
+	// Duration literals are not valid go expressions, they are replaced by
+	// variables.
+	expr, durations, err := replaceDurations(expression)
+	if err != nil {
+		return nil, errors.Wrapf(err, "eval error for expression: \"%s\"", expression)
+	}
+	if len(durations) > 0 {
+		all := make(map[string]interface{}, len(vars)+len(durations))
+		for name, v := range vars {
+			all[name] = v
+		}
+		for name, d := range durations {
+			all[name] = d
 		}
-	}()
+		vars = all
+	}
 
 	// parse expression
-	expr, err := parser.ParseExpr(expression)
+	parsed, err := parser.ParseExpr(expr)
 	if err != nil {
 		msg := fmt.Sprintf("eval error for expression: \"%s\"", expression)
-		return 0, errors.New(msg)
+		return nil, errors.New(msg)
 	}
 
 	// evaluate expression
-	return eval(expr), nil
+	v, err := eval(parsed, vars)
+	if err != nil {
+		return nil, errors.Wrapf(err, "eval error for expression: \"%s\"", expression)
+	}
+	return v, nil
 }
 
-// eval evaluates an ast.Expr, panicking when there is a problem.
-// This function is called by Eval which recovers from the panics.
-func eval(expr ast.Expr) float64 {
+// durationRegex matches a duration literal, see time.ParseDuration.
+var durationRegex = regexp.MustCompile(`(^|[^\w.])((?:(?:\d+\.?\d*|\.\d+)(?:ns|us|µs|ms|s|m|h))+)\b`)
+
+// replaceDurations replaces the duration literals in an expression by
+// variables and returns the values of the variables.
+func replaceDurations(expr string) (string, map[string]interface{}, error) {
+	durations := make(map[string]interface{})
+	var err error
+	expr = durationRegex.ReplaceAllStringFunc(expr, func(match string) string {
+		m := durationRegex.FindStringSubmatch(match)
+		d, parseErr := time.ParseDuration(m[2])
+		if parseErr != nil {
+			err = parseErr
+			return match
+		}
+		name := fmt.Sprintf("_duration%d", len(durations))
+		durations[name] = d
+		return m[1] + name
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return expr, durations, nil
+}
+
+// eval evaluates an ast.Expr.
+func eval(expr ast.Expr, vars map[string]interface{}) (interface{}, error) {
 	switch e := expr.(type) {
 	case *ast.ParenExpr:
-		return eval(e.X)
+		return eval(e.X, vars)
 
 	case *ast.BinaryExpr:
-		return evalBin(e)
+		return evalBin(e, vars)
+
+	case *ast.UnaryExpr:
+		return evalUnary(e, vars)
+
+	case *ast.CallExpr:
+		return evalCall(e, vars)
+
+	case *ast.Ident:
+		if v, ok := vars[e.Name]; ok {
+			return v, nil
+		}
+		switch e.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		msg := fmt.Sprintf("unknown identifier %s", e.Name)
+		return nil, errors.New(msg)
 
 	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			msg := fmt.Sprintf("%s is not a number", e.Value)
+			return nil, errors.New(msg)
+		}
 		v, err := strconv.ParseFloat(e.Value, 64)
 		if err != nil {
-			panic(fmt.Sprintf("cannot convert BasicLit to float, %v", e))
+			msg := fmt.Sprintf("cannot convert %s to a number", e.Value)
+			return nil, errors.New(msg)
+		}
+		return v, nil
+	}
+
+	msg := fmt.Sprintf("calculator doesn't handle type %T", expr)
+	return nil, errors.New(msg)
+}
+
+// evalBin executes a binary operator on two operands. The arithmetic and
+// comparison operators apply to numbers, "&&" and "||" apply to bools and
+// "==" and "!=" apply to both.
+func evalBin(expr *ast.BinaryExpr, vars map[string]interface{}) (interface{}, error) {
+	x, err := eval(expr.X, vars)
+	if err != nil {
+		return nil, err
+	}
+	y, err := eval(expr.Y, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if bx, ok := x.(bool); ok {
+		if by, ok := y.(bool); ok {
+			switch expr.Op {
+			case token.LAND:
+				return bx && by, nil
+			case token.LOR:
+				return bx || by, nil
+			case token.EQL:
+				return bx == by, nil
+			case token.NEQ:
+				return bx != by, nil
+			}
+		}
+	}
+
+	if (expr.Op == token.QUO || expr.Op == token.REM) && isZero(y) {
+		msg := fmt.Sprintf("division by zero: %v %s %v", x, expr.Op, y)
+		return nil, errors.New(msg)
+	}
+
+	_, durx := x.(time.Duration)
+	_, dury := y.(time.Duration)
+	if durx || dury {
+		return evalDuration(expr.Op, x, y)
+	}
+
+	fx, okx := x.(float64)
+	fy, oky := y.(float64)
+	if !okx || !oky {
+		return nil, undefinedOperator(expr.Op, x, y)
+	}
+
+	switch expr.Op {
+	case token.MUL:
+		return fx * fy, nil
+	case token.QUO:
+		return fx / fy, nil
+	case token.REM:
+		return math.Mod(fx, fy), nil
+	case token.ADD:
+		return fx + fy, nil
+	case token.SUB:
+		return fx - fy, nil
+	case token.LSS:
+		return fx < fy, nil
+	case token.LEQ:
+		return fx <= fy, nil
+	case token.GTR:
+		return fx > fy, nil
+	case token.GEQ:
+		return fx >= fy, nil
+	case token.EQL:
+		return fx == fy, nil
+	case token.NEQ:
+		return fx != fy, nil
+	}
+
+	msg := fmt.Sprintf("unsupported operator %s", expr.Op)
+	return nil, errors.New(msg)
+}
+
+// evalDuration executes a binary operator of which at least one operand is a
+// duration.
+func evalDuration(op token.Token, x, y interface{}) (interface{}, error) {
+	dx, durx := x.(time.Duration)
+	dy, dury := y.(time.Duration)
+	fx, numx := x.(float64)
+	fy, numy := y.(float64)
+
+	switch {
+	case durx && dury:
+		switch op {
+		case token.ADD:
+			return dx + dy, nil
+		case token.SUB:
+			return dx - dy, nil
+		case token.QUO:
+			return float64(dx) / float64(dy), nil
+		case token.REM:
+			return dx % dy, nil
+		case token.LSS:
+			return dx < dy, nil
+		case token.LEQ:
+			return dx <= dy, nil
+		case token.GTR:
+			return dx > dy, nil
+		case token.GEQ:
+			return dx >= dy, nil
+		case token.EQL:
+			return dx == dy, nil
+		case token.NEQ:
+			return dx != dy, nil
+		}
+
+	case durx && numy:
+		switch op {
+		case token.MUL:
+			return time.Duration(float64(dx) * fy), nil
+		case token.QUO:
+			return time.Duration(float64(dx) / fy), nil
+		}
+
+	case numx && dury:
+		if op == token.MUL {
+			return time.Duration(fx * float64(dy)), nil
+		}
+	}
+
+	return nil, undefinedOperator(op, x, y)
+}
+
+// isZero returns whether v is the number or the duration zero.
+func isZero(v interface{}) bool {
+	return v == interface{}(0.0) || v == interface{}(time.Duration(0))
+}
+
+func undefinedOperator(op token.Token, x, y interface{}) error {
+	msg := fmt.Sprintf("operator %s not defined on %s and %s", op, typeName(x), typeName(y))
+	return errors.New(msg)
+}
+
+// evalUnary executes the unary operators "-" and "+" on a number or duration
+// and "!" on a bool.
+func evalUnary(expr *ast.UnaryExpr, vars map[string]interface{}) (interface{}, error) {
+	x, err := eval(expr.X, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := x.(type) {
+	case float64:
+		switch expr.Op {
+		case token.SUB:
+			return -v, nil
+		case token.ADD:
+			return v, nil
+		}
+	case time.Duration:
+		switch expr.Op {
+		case token.SUB:
+			return -v, nil
+		case token.ADD:
+			return v, nil
+		}
+	case bool:
+		if expr.Op == token.NOT {
+			return !v, nil
 		}
-		return v
 	}
 
-	panic(fmt.Sprintf("calculator doesn't handle type %T", expr))
-	return 0
+	msg := fmt.Sprintf("operator %s not defined on %s", expr.Op, typeName(x))
+	return nil, errors.New(msg)
+}
+
+// function is a function that can be called in an expression. A negative
+// arity means the function takes one or more arguments. Functions that accept
+// durations return a duration when their arguments are durations.
+type function struct {
+	arity     int
+	fn        func(args []float64) float64
+	durations bool
+}
+
+func unary(fn func(float64) float64) function {
+	return function{1, func(args []float64) float64 { return fn(args[0]) }, false}
+}
+
+var functions = map[string]function{
+	"min": {-1, func(args []float64) float64 {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result
+	}, true},
+	"max": {-1, func(args []float64) float64 {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result
+	}, true},
+	"ceil":  unary(math.Ceil),
+	"floor": unary(math.Floor),
+	"round": unary(math.Round),
+	"log":   unary(math.Log),
+	"log2":  unary(math.Log2),
+	"sqrt":  unary(math.Sqrt),
+	"pow":   {2, func(args []float64) float64 { return math.Pow(args[0], args[1]) }, false},
 }
 
-// evalBin executes the binary operator "+", "-", "*" or "/" on two
-func evalBin(expr *ast.BinaryExpr) float64 {
-	x := eval(expr.X)
-	y := eval(expr.Y)
+// evalCall calls one of the functions with numeric arguments.
+func evalCall(expr *ast.CallExpr, vars map[string]interface{}) (interface{}, error) {
+	ident, ok := expr.Fun.(*ast.Ident)
+	if !ok {
+		return nil, errors.New("only named functions can be called")
+	}
+	fn, ok := functions[ident.Name]
+	if !ok {
+		msg := fmt.Sprintf("unknown function %s", ident.Name)
+		return nil, errors.New(msg)
+	}
+	if fn.arity >= 0 && len(expr.Args) != fn.arity || len(expr.Args) == 0 {
+		msg := fmt.Sprintf("%s expects %d arguments, got %d", ident.Name, fn.arity, len(expr.Args))
+		if fn.arity < 0 {
+			msg = fmt.Sprintf("%s expects at least one argument", ident.Name)
+		}
+		return nil, errors.New(msg)
+	}
 
-	switch expr.Op.String() {
-	case "*":
-		return x * y
-	case "/":
-		return x / y
-	case "+":
-		return x + y
-	case "-":
-		return x - y
+	args := make([]float64, len(expr.Args))
+	var durations int
+	for i, argExpr := range expr.Args {
+		arg, err := eval(argExpr, vars)
+		if err != nil {
+			return nil, err
+		}
+		switch v := arg.(type) {
+		case float64:
+			args[i] = v
+		case time.Duration:
+			if !fn.durations {
+				msg := fmt.Sprintf("%s expects numbers, got %s", ident.Name, typeName(arg))
+				return nil, errors.New(msg)
+			}
+			args[i] = float64(v)
+			durations++
+		default:
+			msg := fmt.Sprintf("%s expects numbers, got %s", ident.Name, typeName(arg))
+			return nil, errors.New(msg)
+		}
 	}
 
-	panic(fmt.Sprintf("unsupported operator %s", expr.Op))
-	return 0
+	switch durations {
+	case 0:
+		return fn.fn(args), nil
+	case len(args):
+		return time.Duration(fn.fn(args)), nil
+	}
+	msg := fmt.Sprintf("%s expects either numbers or durations", ident.Name)
+	return nil, errors.New(msg)
+}
+
+// typeName returns the name of the type of a value in an expression.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case time.Duration:
+		return "duration"
+	case bool:
+		return "bool"
+	}
+	return fmt.Sprintf("%T", v)
 }
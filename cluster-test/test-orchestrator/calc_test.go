@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 func ExampleEval() {
 	fmt.Println(Eval(""))
@@ -16,9 +19,21 @@ func ExampleEval() {
 	fmt.Println(Eval("(1.5*3)*(3+4)"))
 	fmt.Println(Eval("(1.5*(3))*(3+4)"))
 
+	fmt.Println(Eval("-2*-3"))
+	fmt.Println(Eval("7%3"))
+	fmt.Println(Eval("max(1, 40/10, 2)"))
+	fmt.Println(Eval("ceil(log2(10))*3"))
+	fmt.Println(Eval("round(2.5) + floor(2.5) + sqrt(16) + pow(2, 3) + min(3, 1)"))
+	fmt.Println(Eval("round(-2.5)"))
+	fmt.Println(Eval("N*2"))
+	fmt.Println(Eval("mean(1, 2)"))
+	fmt.Println(Eval("pow(2)"))
+	fmt.Println(Eval("1 < 2"))
+	fmt.Println(Eval("!1"))
+
 	// Output:
 	// 0 eval error for expression: ""
-	// 0 eval error for expression: "1s"
+	// 0 expression "1s" is not a number
 	// 0 eval error for expression: "2+(3*4"
 	// 0 eval error for expression: "2+3*4)"
 	// 0 eval error for expression: "(1.5+)*(3+4)"
@@ -28,5 +43,73 @@ func ExampleEval() {
 	// 16 <nil>
 	// 31.5 <nil>
 	// 31.5 <nil>
+	// 6 <nil>
+	// 1 <nil>
+	// 4 <nil>
+	// 12 <nil>
+	// 18 <nil>
+	// -3 <nil>
+	// 0 eval error for expression: "N*2": unknown identifier N
+	// 0 eval error for expression: "mean(1, 2)": unknown function mean
+	// 0 eval error for expression: "pow(2)": pow expects 2 arguments, got 1
+	// 0 expression "1 < 2" is not a number
+	// 0 eval error for expression: "!1": operator ! not defined on number
+
+}
+
+func ExampleEvaluate() {
+	vars := map[string]interface{}{"N": 10.0, "FAST": true}
+	fmt.Println(Evaluate("max(1, N/4)", vars))
+	fmt.Println(Evaluate("N >= 10 && !FAST", vars))
+	fmt.Println(Evaluate("N == 10 || FAST", vars))
+	fmt.Println(Evaluate("N + FAST", vars))
+	fmt.Println(Evaluate("M", vars))
+
+	// Output:
+	// 2.5 <nil>
+	// false <nil>
+	// true <nil>
+	// <nil> eval error for expression: "N + FAST": operator + not defined on number and bool
+	// <nil> eval error for expression: "M": unknown identifier M
+}
+
+func ExampleEvaluate_durations() {
+	vars := map[string]interface{}{"N": 10.0, "T": 2 * time.Second}
+	fmt.Println(Evaluate("N*200ms", vars))
+	fmt.Println(Evaluate("2*1s+500ms", vars))
+	fmt.Println(Evaluate("1m30s - T/4", vars))
+	fmt.Println(Evaluate("-T", vars))
+	fmt.Println(Evaluate("T/500ms", vars))
+	fmt.Println(Evaluate("max(1s, T, 1.5s)", vars))
+	fmt.Println(Evaluate("T >= 2000ms", vars))
 
+	fmt.Println(Evaluate("1s + 1", vars))
+	fmt.Println(Evaluate("T*1s", vars))
+	fmt.Println(Evaluate("2/T", vars))
+	fmt.Println(Evaluate("max(1s, 2)", vars))
+	fmt.Println(Evaluate("ceil(T)", vars))
+	fmt.Println(Evaluate("1s % 0s", vars))
+	fmt.Println(Evaluate("T / (N-10)", vars))
+	fmt.Println(Evaluate("5 % 0", vars))
+	fmt.Println(Evaluate("N / 0", vars))
+	fmt.Println(Evaluate("1s + 99999999999h", vars))
+
+	// Output:
+	// 2s <nil>
+	// 2.5s <nil>
+	// 1m29.5s <nil>
+	// -2s <nil>
+	// 4 <nil>
+	// 2s <nil>
+	// true <nil>
+	// <nil> eval error for expression: "1s + 1": operator + not defined on duration and number
+	// <nil> eval error for expression: "T*1s": operator * not defined on duration and duration
+	// <nil> eval error for expression: "2/T": operator / not defined on number and duration
+	// <nil> eval error for expression: "max(1s, 2)": max expects either numbers or durations
+	// <nil> eval error for expression: "ceil(T)": ceil expects numbers, got duration
+	// <nil> eval error for expression: "1s % 0s": division by zero: 1s % 0s
+	// <nil> eval error for expression: "T / (N-10)": division by zero: 2s / 0
+	// <nil> eval error for expression: "5 % 0": division by zero: 5 % 0
+	// <nil> eval error for expression: "N / 0": division by zero: 10 / 0
+	// <nil> eval error for expression: "1s + 99999999999h": time: invalid duration "99999999999h"
 }
@@ -25,8 +25,6 @@ import (
 	"strings"
 )
 
-var ringpopPort = "3000"
-
 // Command runs command that affect the cluster in different ways. Commands are
 // commenly used to form the Script field of the Scenario struct.
 type Command struct {
@@ -36,10 +34,16 @@ type Command struct {
 	// Cmd can be one of:
 	// - `cluster-kill`
 	// - `cluster-start`
-	// - `cluster-rolling-restart`
+	// - `cluster-rolling-restart [<INTERVAL>]`
+	// - `kill <COUNT>`
+	// - `start <COUNT>`
 	// - `network-drop <SPLIT> <PERCENTAGE>`
 	// - `network-delay <SPLIT> <DURATION>`
-	// - `wait-for-stable`
+	// - `network-partition <GROUPS>`
+	// - `network-heal`
+	// - `wait-for-stable [<TIMEOUT>]`
+	//
+	// The commands are executed by the handlers of an Executor.
 	Cmd string
 
 	// The arguments of the command.
@@ -0,0 +1,180 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config holds the settings of the orchestrator. The settings are declared in
+// the config section of the test yaml and can be overridden per scenario and
+// by command-line flags.
+type Config struct {
+	// The ringpop binary that is tested and its arguments, see LocalCluster
+	// for the variables that can be used in the arguments.
+	Binary string
+	Args   []string
+
+	// The host the nodes listen on and the port of the first node.
+	Host     string
+	BasePort int
+
+	// Front every node with a fault-injection proxy, see LocalCluster.
+	Proxy bool
+
+	// The udp port the stats are received on.
+	StatsPort int
+
+	// The directory the stats files and node logs are written to.
+	StatsDir string
+
+	// The first element of the path of every stat, the hostport of the node
+	// follows the prefix: "<prefix>.<hostport>.<stat>".
+	StatsPrefix string
+
+	// The time wait-for-stable waits for the cluster to become stable when
+	// the command has no timeout argument. Zero means no timeout.
+	StableTimeout time.Duration
+
+	// The duration the cluster must stay stable before wait-for-stable
+	// returns.
+	StableFor time.Duration
+
+	// The duration after which a node that should be alive but emits no
+	// stats keeps the cluster from being stable. Zero disables the check.
+	SilentAfter time.Duration
+
+	// Stop a scenario when a measurement that is required to pass fails.
+	// The rest of the script doesn't run and the remaining measurements are
+	// skipped, see Runner.Run.
+	AbortOnFailure bool
+}
+
+// defaultConfig returns the Config that is used when the test yaml declares
+// no settings.
+func defaultConfig() *Config {
+	return &Config{
+		Args:          defaultNodeArgs,
+		Host:          "127.0.0.1",
+		BasePort:      3000,
+		StatsPort:     3300,
+		StatsDir:      ".",
+		StatsPrefix:   "ringpop",
+		StableTimeout: 5 * time.Minute,
+		SilentAfter:   5 * time.Second,
+	}
+}
+
+// StatsAddr returns the hostport the nodes send their stats to.
+func (c *Config) StatsAddr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.StatsPort)
+}
+
+// A SettingError is returned by merge when the value of a setting is invalid.
+type SettingError struct {
+	// The name of the setting as it appears in the yaml, e.g. "stats-port".
+	Setting string
+	Err     error
+}
+
+func (e *SettingError) Error() string {
+	return e.Setting + ": " + e.Err.Error()
+}
+
+// merge returns a copy of the Config with the settings that are declared in
+// the yaml applied. A SettingError is returned when a setting is invalid.
+func (c *Config) merge(data configYaml) (*Config, error) {
+	result := *c
+	var err error
+
+	if data.Binary != "" {
+		result.Binary = data.Binary
+	}
+	if data.Args != nil {
+		result.Args = data.Args
+	}
+	if data.Host != "" {
+		result.Host = data.Host
+	}
+	if data.Proxy != nil {
+		result.Proxy = *data.Proxy
+	}
+	if data.AbortOnFailure != nil {
+		result.AbortOnFailure = *data.AbortOnFailure
+	}
+	if data.StatsDir != "" {
+		result.StatsDir = data.StatsDir
+	}
+	if data.StatsPrefix != "" {
+		result.StatsPrefix = data.StatsPrefix
+	}
+	if data.BasePort != "" {
+		if result.BasePort, err = parsePort(data.BasePort); err != nil {
+			return nil, &SettingError{Setting: "base-port", Err: err}
+		}
+	}
+	if data.StatsPort != "" {
+		if result.StatsPort, err = parsePort(data.StatsPort); err != nil {
+			return nil, &SettingError{Setting: "stats-port", Err: err}
+		}
+	}
+	if data.StableTimeout != "" {
+		if result.StableTimeout, err = parseDuration(data.StableTimeout); err != nil {
+			return nil, &SettingError{Setting: "stable-timeout", Err: err}
+		}
+	}
+	if data.StableFor != "" {
+		if result.StableFor, err = parseDuration(data.StableFor); err != nil {
+			return nil, &SettingError{Setting: "stable-for", Err: err}
+		}
+	}
+	if data.SilentAfter != "" {
+		if result.SilentAfter, err = parseDuration(data.SilentAfter); err != nil {
+			return nil, &SettingError{Setting: "silent-after", Err: err}
+		}
+	}
+
+	return &result, nil
+}
+
+// parsePort parses a port number.
+func parsePort(str string) (int, error) {
+	port, err := strconv.Atoi(str)
+	if err != nil || port <= 0 || port > 65535 {
+		msg := fmt.Sprintf("%s is not a valid port", str)
+		return 0, errors.New(msg)
+	}
+	return port, nil
+}
+
+// parseDuration parses a duration that can't be negative.
+func parseDuration(str string) (time.Duration, error) {
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		msg := fmt.Sprintf("%s is not a valid duration", str)
+		return 0, errors.New(msg)
+	}
+	return d, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// An Executor executes the commands of a script against a ringpop cluster.
+// Different backends can run the cluster in different ways, for example as
+// processes on the local machine.
+type Executor interface {
+	// Handlers returns the commands the Executor implements.
+	Handlers() CommandHandlers
+
+	// Hosts returns the hostports of the nodes that should be alive.
+	Hosts() []string
+
+	// Close tears down the cluster.
+	Close() error
+}
+
+// A CommandHandler executes a single command given its arguments.
+type CommandHandler func(args []string) error
+
+// CommandHandlers maps command names to the handlers that implement them.
+type CommandHandlers map[string]CommandHandler
+
+// Execute looks up the handler of the command and executes it.
+func (hs CommandHandlers) Execute(cmd *Command) error {
+	h, ok := hs[cmd.Cmd]
+	if !ok {
+		msg := fmt.Sprintf("unsupported command %s", cmd.Cmd)
+		return errors.New(msg)
+	}
+
+	return h(cmd.Args)
+}
+
+// Merge returns new CommandHandlers that contain the handlers of both. The
+// handlers of other take precedence.
+func (hs CommandHandlers) Merge(other CommandHandlers) CommandHandlers {
+	result := make(CommandHandlers, len(hs)+len(other))
+	for name, h := range hs {
+		result[name] = h
+	}
+	for name, h := range other {
+		result[name] = h
+	}
+	return result
+}
+
+// expectArgs returns an error when the number of arguments is not between
+// min and max.
+func expectArgs(args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		msg := fmt.Sprintf("expected %d to %d arguments, got %d", min, max, len(args))
+		if min == max {
+			msg = fmt.Sprintf("expected %d arguments, got %d", min, len(args))
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The file contains the script fragments of the test yaml. A fragment is a
+// named list of commands that can be invoked in the script of any scenario,
+// e.g. a common preamble:
+//
+// fragments:
+//   kill-some:
+//     params: [<COUNT>]
+//     script:
+//     - kill: kill <COUNT>
+//     - stable: wait-for-stable
+//
+// scenarios:
+// - script:
+//   - t0: cluster-start
+//   - t1: kill-some {<N>/2}
+//
+// The commands of an invoked fragment are labelled with the label of the
+// invocation and their own label, the example above results in the labels t0,
+// t1.kill and t1.stable. Fragments can be declared in other files that are
+// listed in the include section of the test yaml. A fragment can't have the
+// name of a built-in command.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fragmentsYaml is used to unmarshal a file that is included by a test yaml,
+// it declares fragments and can include other files.
+type fragmentsYaml struct {
+	Include   []yaml.Node
+	Fragments map[string]*fragmentYaml
+}
+
+// fragmentYaml captures a named script fragment. The params are variables
+// that are bound to the arguments of the invocation, the variables of the run
+// can be used in the fragment as well.
+type fragmentYaml struct {
+	Params yaml.Node
+	Script []yaml.Node
+
+	// The name of the fragment, the file it is declared in and the mapping
+	// node it is declared in.
+	name string
+	file string
+	node *yaml.Node
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to remember the position of the
+// fragment.
+func (f *fragmentYaml) UnmarshalYAML(node *yaml.Node) error {
+	type plain fragmentYaml
+	f.node = node
+	return node.Decode((*plain)(f))
+}
+
+// addFragments adds the fragments that are declared in a file and the files
+// it includes to the fragments of the parser.
+func (p *yamlParser) addFragments(file string, include []yaml.Node, fragments map[string]*fragmentYaml) {
+	if p.fragments == nil {
+		p.fragments = make(map[string]*fragmentYaml)
+		p.included = map[string]bool{file: true}
+	}
+
+	sc := scope{file: file, field: "fragments"}
+	for name, fragment := range fragments {
+		fragment.name = name
+		fragment.file = file
+		if strings.ContainsAny(name, " \t") {
+			p.addError(sc, fragment.node, name, errors.New("fragment name should be a single word"))
+			continue
+		}
+		if _, ok := commandChecks[name]; ok {
+			p.addError(sc, fragment.node, name, errors.New("fragment name is a built-in command"))
+			continue
+		}
+		if other, ok := p.fragments[name]; ok {
+			msg := fmt.Sprintf("fragment is already declared at %s line %d", other.file, other.node.Line)
+			p.addError(sc, fragment.node, name, errors.New(msg))
+			continue
+		}
+		p.fragments[name] = fragment
+	}
+
+	sc.field = "include"
+	for i := range include {
+		node := &include[i]
+		path, ok := p.scalar(sc, node)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		if p.included[path] {
+			continue
+		}
+		p.included[path] = true
+
+		bts, err := ioutil.ReadFile(path)
+		if err != nil {
+			p.addError(sc, node, path, errors.New("failed to read included file"))
+			continue
+		}
+
+		// included files may only declare fragments
+		data := &fragmentsYaml{}
+		decoder := yaml.NewDecoder(bytes.NewReader(bts))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(data); err != nil {
+			p.errs = append(p.errs, yamlParseErrors(path, err)...)
+			if _, ok := err.(*yaml.TypeError); !ok {
+				continue
+			}
+		}
+		p.addFragments(path, data.Include, data.Fragments)
+	}
+}
+
+// expandFragment returns the commands of an invoked fragment. The node is
+// the node of the invocation and the stack holds the names of the fragments
+// the invocation is part of.
+func (p *yamlParser) expandFragment(sc scope, node *yaml.Node, fragment *fragmentYaml, invocation *Command, vars bindings, stack []string) []*Command {
+	text := invocation.Label + ": " + strings.Join(append([]string{invocation.Cmd}, invocation.Args...), " ")
+	for _, name := range stack {
+		if name == fragment.name {
+			msg := fmt.Sprintf("fragment '%s' invokes itself", fragment.name)
+			p.addError(sc, node, text, errors.New(msg))
+			return nil
+		}
+	}
+
+	fsc := scope{
+		file:     fragment.file,
+		scenario: sc.scenario,
+		run:      sc.run,
+		field:    fmt.Sprintf("fragment '%s'", fragment.name),
+	}
+
+	var params []string
+	if fragment.Params.Kind != 0 {
+		var ok bool
+		if params, ok = p.extractVars(fsc, &fragment.Params); !ok {
+			return nil
+		}
+	}
+	if len(params) != len(invocation.Args) {
+		msg := fmt.Sprintf("fragment '%s' expects %d arguments, got %d", fragment.name, len(params), len(invocation.Args))
+		p.addError(sc, node, text, errors.New(msg))
+		return nil
+	}
+
+	fragmentVars := vars.with(bind(params, invocation.Args))
+	stack = append(stack, fragment.name)
+
+	var cmds []*Command
+	for i := range fragment.Script {
+		for _, cmd := range p.extractEntry(fsc, &fragment.Script[i], fragmentVars, stack) {
+			cmd.Label = invocation.Label + "." + cmd.Label
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
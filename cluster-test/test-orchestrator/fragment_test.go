@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func Example_parseFragments() {
+	dir, err := ioutil.TempDir("", "fragments")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "common.yaml"), []byte(commonTestYaml), 0644)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	scns, err := parseFile(filepath.Join(dir, "test.yaml"), []byte(fragmentsTestYaml))
+	fmt.Println(err)
+	for _, scn := range scns {
+		for _, cmd := range scn.Script {
+			fmt.Println(cmd.Label, cmd.Cmd, cmd.Args)
+		}
+	}
+
+	_, err = parseFile(filepath.Join(dir, "test.yaml"), []byte(`
+include: [common.yaml, missing.yaml]
+fragments:
+  loop:
+    script:
+    - again: loop
+  kill:
+    script:
+    - kill: cluster-kill
+scenarios:
+- name: errors
+  size: 3
+  script:
+  - t0: start-stable
+  - t0.start: cluster-kill
+  - t1: kill-some
+  - t2: loop
+  runs:
+  - [<N>]
+  - [3]
+`))
+	fmt.Println(strings.Replace(fmt.Sprint(err), dir+"/", "", -1))
+
+	// Output:
+	// <nil>
+	// t0 cluster-start []
+	// t1.start cluster-start []
+	// t1.stable wait-for-stable []
+	// t2.kill kill [2]
+	// t2.stable wait-for-stable [10s]
+	// t3 cluster-kill []
+	// test.yaml: line 2, column 24: include 'missing.yaml': failed to read included file
+	// test.yaml: line 6, column 14: scenario 'errors' run 1 fragment 'loop' 'again: loop': fragment 'loop' invokes itself
+	// test.yaml: line 8, column 5: fragments 'kill': fragment name is a built-in command
+	// test.yaml: line 15, column 5: scenario 'errors' run 1 script 't0.start': label is used more than once
+	// test.yaml: line 16, column 9: scenario 'errors' run 1 script 't1: kill-some': fragment 'kill-some' expects 1 arguments, got 0
+}
+
+var commonTestYaml = `
+fragments:
+  start-stable:
+    script:
+    - start: cluster-start
+    - stable: wait-for-stable
+  kill-some:
+    params: [<COUNT>]
+    script:
+    - kill: kill <COUNT>
+    - stable: wait-for-stable <TIMEOUT>
+`
+
+var fragmentsTestYaml = `
+include:
+- common.yaml
+
+scenarios:
+- name: fragments
+  size: <N>
+  script:
+  - t0: cluster-start
+  - t1: start-stable
+  - t2: kill-some {<N>/2}
+  - t3: cluster-kill
+  runs:
+  - [<N>, <TIMEOUT>]
+  - [4, 10s]
+`
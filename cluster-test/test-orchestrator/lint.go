@@ -0,0 +1,231 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The file contains the static checks of the lint mode. The checks find
+// mistakes in a test yaml that would otherwise only be found after a cluster is
+// started, e.g. a measurement that refers to a label that is not in the script
+// or a command with the wrong number of arguments.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// commandChecks validate the arguments of the commands, see Command, for a
+// cluster of the given size. They use the argument parsing of the command
+// handlers so that lint accepts exactly what the handlers accept.
+var commandChecks = map[string]func(args []string, size int) error{
+	"cluster-start": checkNoArgs,
+	"cluster-kill":  checkNoArgs,
+	"cluster-rolling-restart": func(args []string, size int) error {
+		_, err := parseInterval(args)
+		return err
+	},
+	"kill":  checkCount,
+	"start": checkCount,
+	"network-drop": func(args []string, size int) error {
+		_, _, err := parseDropArgs(args, size)
+		return err
+	},
+	"network-delay": func(args []string, size int) error {
+		_, _, err := parseDelayArgs(args, size)
+		return err
+	},
+	"network-partition": func(args []string, size int) error {
+		_, err := parsePartitionArgs(args, size)
+		return err
+	},
+	"network-heal": checkNoArgs,
+	"wait-for-stable": func(args []string, size int) error {
+		_, err := parseTimeout(args, 0)
+		return err
+	},
+}
+
+func checkNoArgs(args []string, size int) error {
+	return expectArgs(args, 0, 0)
+}
+
+// checkCount checks the COUNT of the kill and start commands, which can't be
+// larger than the cluster.
+func checkCount(args []string, size int) error {
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	if count > size {
+		msg := fmt.Sprintf("count %d is larger than the cluster size %d", count, size)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func checkDurations(args []string, size int) error {
+	for _, arg := range args {
+		if _, err := time.ParseDuration(arg); err != nil {
+			msg := fmt.Sprintf("%s is not a duration", arg)
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// A quantitySignature describes the arguments and the type of the Value of a
+// quantity.
+type quantitySignature struct {
+	min, max int
+	check    func(args []string) error
+	value    reflect.Type
+}
+
+var (
+	numberType   = reflect.TypeOf(float64(0))
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// quantitySignatures holds the signatures of the quantities, see
+// Measurement.
+var quantitySignatures = map[string]quantitySignature{
+	"convtime":  {0, 0, nil, durationType},
+	"checksums": {0, 0, nil, numberType},
+	"count": {1, 1, func(args []string) error {
+		return checkStatPattern(args[0])
+	}, numberType},
+	"lines": {1, 1, func(args []string) error {
+		_, err := regexp.Compile(args[0])
+		return errors.Wrapf(err, "lines %s", args[0])
+	}, numberType},
+	"silent-nodes": {0, 1, func(args []string) error {
+		return checkDurations(args, 0)
+	}, numberType},
+}
+
+// networkCommands are the commands that are only supported when every node
+// is fronted by a proxy, see LocalCluster.
+var networkCommands = NewNetwork(0).Handlers()
+
+// lintCommand checks that the command exists, that its handler accepts its
+// arguments and that the config supports it.
+func lintCommand(cmd *Command, config *Config, size int) error {
+	check, ok := commandChecks[cmd.Cmd]
+	if !ok {
+		msg := fmt.Sprintf("unknown command %s", cmd.Cmd)
+		return errors.New(msg)
+	}
+	if err := check(cmd.Args, size); err != nil {
+		return err
+	}
+
+	if _, ok := networkCommands[cmd.Cmd]; ok {
+		if !config.Proxy {
+			msg := fmt.Sprintf("%s requires the proxy, see -proxy", cmd.Cmd)
+			return errors.New(msg)
+		}
+		return checkProxyArgs(config.Args)
+	}
+	return nil
+}
+
+// lintMeasurement checks that the labels of the measurement are in the
+// script in the right order, that the quantity exists, that its arguments
+// match its signature and that the assertion compares values of the type of
+// the quantity.
+func lintMeasurement(m *Measurement, script []*Command) error {
+	if m.Iterations > 0 {
+		for i := 1; i <= m.Iterations; i++ {
+			if err := lintMeasurement(m.Iteration(i), script); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start, end := -1, len(script)
+	for i, cmd := range script {
+		if cmd.Label == m.Start {
+			start = i
+		}
+		if cmd.Label == m.End {
+			end = i
+		}
+	}
+	if m.Start != scriptStartLabel && start == -1 {
+		msg := fmt.Sprintf("start label %s is not in the script", m.Start)
+		return errors.New(msg)
+	}
+	if m.End != scriptEndLabel && end == len(script) {
+		msg := fmt.Sprintf("end label %s is not in the script", m.End)
+		return errors.New(msg)
+	}
+	if end <= start {
+		msg := fmt.Sprintf("end label %s is not after start label %s", m.End, m.Start)
+		return errors.New(msg)
+	}
+
+	sig, ok := quantitySignatures[m.Quantity]
+	if !ok {
+		msg := fmt.Sprintf("no such quantity: %s", m.Quantity)
+		return errors.New(msg)
+	}
+	if m.PerNode {
+		if err := m.checkPerNode(); err != nil {
+			return err
+		}
+	}
+	if err := expectArgs(m.Args, sig.min, sig.max); err != nil {
+		return errors.Wrap(err, m.Quantity)
+	}
+	if sig.check != nil {
+		if err := sig.check(m.Args); err != nil {
+			return err
+		}
+	}
+
+	if a := m.Assertion; a != nil {
+		for _, v := range a.comparedValues() {
+			if e, ok := v.(BaselineExpr); ok {
+				var err error
+				if v, err = e.evaluate(reflect.Zero(sig.value).Interface()); err != nil {
+					msg := fmt.Sprintf("%s is a %s but the assertion compares with %s", m.Quantity, valueTypeName(sig.value), e)
+					return errors.New(msg)
+				}
+			}
+			if reflect.TypeOf(zeroAsDuration(v, reflect.Zero(sig.value).Interface())) != sig.value {
+				msg := fmt.Sprintf("%s is a %s but the assertion compares with %v", m.Quantity, valueTypeName(sig.value), v)
+				return errors.New(msg)
+			}
+		}
+	}
+	return nil
+}
+
+// valueTypeName returns the name of the type of a Value in the test yaml.
+func valueTypeName(t reflect.Type) string {
+	if t == durationType {
+		return "duration"
+	}
+	return "number"
+}
@@ -0,0 +1,112 @@
+package main
+
+import "fmt"
+
+func Example_lintFile() {
+	fmt.Println(lintFile("", []byte(configTestYaml), configYaml{}))
+	fmt.Println(lintFile("", []byte(lintTestYaml), configYaml{}))
+
+	// Output:
+	// <nil>
+	// line 7, column 9: scenario 'lint' run 1 script 'kill 5': count 5 is larger than the cluster size 4
+	// line 8, column 9: scenario 'lint' run 1 script 'network-drop 0|1': expected 2 arguments, got 1
+	// line 9, column 9: scenario 'lint' run 1 script 'network-delay 0|9 10ms': split 0|9: node range 9 out of bounds for cluster size 4
+	// line 10, column 9: scenario 'lint' run 1 script 'wait-for-stabel': unknown command wait-for-stabel
+	// line 12, column 5: scenario 'lint' run 1 measure 't0 t9 convtime': end label t9 is not in the script
+	// line 13, column 5: scenario 'lint' run 1 measure 't2 t1 convtime': end label t1 is not after start label t2
+	// line 14, column 5: scenario 'lint' run 1 measure 't0 t1 count': count: expected 1 arguments, got 0
+	// line 15, column 5: scenario 'lint' run 1 measure 't0 t1 convtime is 3': convtime is a duration but the assertion compares with 3
+	// line 16, column 5: scenario 'lint' run 1 measure 't0 .. count ping.send in (1s, 2s)': count is a number but the assertion compares with 1s
+	// line 17, column 5: scenario 'lint' run 1 measure '.. .. latency': no such quantity: latency
+	// line 18, column 5: scenario 'lint' run 1 measure 't0 t1 convtime <= baseline + 2': convtime is a duration but the assertion compares with baseline + 2
+	// line 21, column 5: scenario 'lint' run 1 measure 't0 t1 count ping[.send': stat pattern ping[.send: syntax error in pattern
+	// line 22, column 5: scenario 'lint' run 1 measure 't0 t1 per-node silent-nodes': silent-nodes can't be measured per node
+	// line 24, column 5: scenario 'lint' run 1 measure 't0 t1 per-node checksums spread': checksums per node can't be aggregated with spread
+	// line 25, column 5: scenario 'lint' run 1 measure 't0 t1 per-node checksums not (> 1 or is 2)': checksums per node can't be compared with not (> 1 or is 2)
+}
+
+var lintTestYaml = `
+scenarios:
+- name: lint
+  size: <N>
+  script:
+  - t0: cluster-start
+  - t1: kill 5
+  - t2: network-drop 0|1
+  - t3: network-delay 0|9 10ms
+  - t4: wait-for-stabel
+  measure:
+  - t0 t9 convtime
+  - t2 t1 convtime
+  - t0 t1 count
+  - t0 t1 convtime is 3
+  - t0 .. count ping.send in (1s, 2s)
+  - .. .. latency
+  - t0 t1 convtime <= baseline + 2
+  - t0 t1 convtime within 10% of baseline
+  - t0 .. checksums is 1
+  - t0 t1 count ping[.send
+  - t0 t1 per-node silent-nodes
+  - t0 t1 per-node convtime max < 2s
+  - t0 t1 per-node checksums spread
+  - t0 t1 per-node checksums not (> 1 or is 2)
+  - t0 t1 per-node checksums != 1
+  runs:
+  - [<N>]
+  - [4]
+`
+
+// The network commands need proxies that the nodes are reachable through.
+func Example_lintFile_proxy() {
+	proxy := true
+	fmt.Println(lintFile("", []byte(proxyTestYaml), configYaml{}))
+	fmt.Println(lintFile("", []byte(proxyTestYaml), configYaml{Proxy: &proxy}))
+	fmt.Println(lintFile("", []byte(proxyTestYaml), configYaml{
+		Proxy: &proxy,
+		Args:  []string{"--listen=<LISTEN>", "--hostport=<HOSTPORT>", "--hosts=<HOSTS>"},
+	}))
+
+	// Output:
+	// line 7, column 9: scenario 'proxy' run 1 script 'network-heal': network-heal requires the proxy, see -proxy
+	// line 7, column 9: scenario 'proxy' run 1 script 'network-heal': the proxy requires nodes that listen on <LISTEN> and advertise <HOSTPORT>, args are "--listen=<LISTEN> --hosts=<HOSTS>"
+	// <nil>
+}
+
+var proxyTestYaml = `
+scenarios:
+- name: proxy
+  size: <N>
+  script:
+  - t0: cluster-start
+  - t1: network-heal
+  runs:
+  - [<N>]
+  - [2]
+`
+
+// Every command that is handled is checked by lint with the argument parsing
+// of its handler.
+func Example_commandChecks() {
+	hs := (&LocalCluster{network: NewNetwork(2)}).Handlers()
+	hs["wait-for-stable"] = nil
+	var unchecked, unhandled []string
+	for name := range hs {
+		if _, ok := commandChecks[name]; !ok {
+			unchecked = append(unchecked, name)
+		}
+	}
+	for name := range commandChecks {
+		if _, ok := hs[name]; !ok {
+			unhandled = append(unhandled, name)
+		}
+	}
+	fmt.Println(unchecked, unhandled)
+
+	fmt.Println(commandChecks["network-delay"]([]string{"0|1", "-1s"}, 2))
+	fmt.Println(hs.Execute(&Command{Cmd: "network-delay", Args: []string{"0|1", "-1s"}}))
+
+	// Output:
+	// [] []
+	// delay -1s is negative
+	// delay -1s is negative
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the LocalCluster Executor which runs every ringpop node
+// as a process on the local machine.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultNodeArgs are the command-line arguments that every ringpop
+// implementation accepts (see test/README.md).
+var defaultNodeArgs = []string{"--listen=<LISTEN>", "--hosts=<HOSTS>"}
+
+// A LocalCluster is an Executor that runs the nodes of the cluster as local
+// processes that listen on consecutive ports starting at BasePort.
+type LocalCluster struct {
+	// The path to the ringpop binary, this can be any ringpop implementation.
+	Binary string
+
+	// The arguments that are passed to the binary. The following variables
+	// are replaced in every argument:
+	//
+	// - <LISTEN>   the hostport the node listens on
+	// - <HOSTPORT> the hostport the node is known by in the cluster
+	// - <HOSTS>    the path to the json file containing the bootstrap hosts
+	// - <STATS>    the hostport the node should send its stats to
+	//
+	// <LISTEN> and <HOSTPORT> only differ when Proxy is set, in which case
+	// both must be used, see checkProxyArgs. When Args is empty,
+	// defaultNodeArgs are used.
+	Args []string
+
+	// The host the nodes listen on.
+	Host string
+
+	// The port of the first node.
+	BasePort int
+
+	// The hostport of the StatIngester.
+	StatsAddr string
+
+	// The directory in which the hosts file and the node logs are written.
+	Dir string
+
+	// When Proxy is set, every node is fronted by a Proxy that listens on the
+	// hostport of the node and injects the faults of the network commands.
+	// The nodes listen on the ports following the hostports of the cluster
+	// and must advertise <HOSTPORT> so that all traffic flows through the
+	// proxies.
+	Proxy bool
+
+	nodes   []*localNode
+	network *Network
+	proxies []*Proxy
+}
+
+// A localNode is a single ringpop process of the LocalCluster.
+type localNode struct {
+	hostport string
+	listen   string
+	cmd      *exec.Cmd
+
+	// exited is closed when the process has exited.
+	exited chan struct{}
+}
+
+// running indicates whether the process of the node was started and has not
+// yet exited.
+func (n *localNode) running() bool {
+	if n.cmd == nil {
+		return false
+	}
+	select {
+	case <-n.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// NewLocalCluster creates a LocalCluster of the given size. No nodes are
+// started until the cluster-start command is executed.
+func NewLocalCluster(lc LocalCluster, size int) (*LocalCluster, error) {
+	c := &lc
+	c.nodes = make([]*localNode, size)
+	hosts := make([]string, size)
+	for i := range c.nodes {
+		hosts[i] = fmt.Sprintf("%s:%d", c.Host, c.BasePort+i)
+		c.nodes[i] = &localNode{hostport: hosts[i], listen: hosts[i]}
+	}
+
+	if c.Proxy {
+		if err := checkProxyArgs(c.Args); err != nil {
+			return nil, errors.Wrap(err, "local cluster")
+		}
+		err := c.startProxies()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// stop the proxies when the cluster can't be created
+	created := false
+	defer func() {
+		if !created {
+			c.Close()
+		}
+	}()
+
+	bts, err := json.Marshal(hosts)
+	if err != nil {
+		return nil, errors.Wrap(err, "local cluster")
+	}
+	err = ioutil.WriteFile(c.hostsFile(), bts, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "local cluster")
+	}
+
+	created = true
+	return c, nil
+}
+
+// Handlers returns the commands the LocalCluster implements:
+//
+//   - `cluster-start` starts all nodes that are not running
+//   - `cluster-kill` kills all nodes
+//   - `cluster-rolling-restart [<INTERVAL>]` restarts the nodes one by one,
+//     waiting INTERVAL between nodes
+//   - `kill <COUNT>` kills COUNT running nodes, highest port first
+//   - `start <COUNT>` starts COUNT nodes that are not running, lowest port first
+//
+// When Proxy is set, the commands of the Network are implemented as well.
+func (c *LocalCluster) Handlers() CommandHandlers {
+	hs := CommandHandlers{
+		"cluster-start":           c.clusterStart,
+		"cluster-kill":            c.clusterKill,
+		"cluster-rolling-restart": c.clusterRollingRestart,
+		"kill":                    c.kill,
+		"start":                   c.start,
+	}
+	if c.network != nil {
+		hs = hs.Merge(c.network.Handlers())
+	}
+	return hs
+}
+
+// Hosts returns the hostports of the running nodes.
+func (c *LocalCluster) Hosts() []string {
+	var hosts []string
+	for _, n := range c.nodes {
+		if n.running() {
+			hosts = append(hosts, n.hostport)
+		}
+	}
+	return hosts
+}
+
+// Close kills all running nodes and stops the proxies.
+func (c *LocalCluster) Close() error {
+	err := c.clusterKill(nil)
+	for _, p := range c.proxies {
+		p.Close()
+	}
+	return err
+}
+
+// startProxies moves the nodes to the ports following the hostports of the
+// cluster and starts a Proxy on every hostport.
+func (c *LocalCluster) startProxies() error {
+	c.network = NewNetwork(len(c.nodes))
+	for i, n := range c.nodes {
+		n.listen = fmt.Sprintf("%s:%d", c.Host, c.BasePort+len(c.nodes)+i)
+		c.network.AddNode(n.hostport, i)
+		c.network.AddNode(n.listen, i)
+
+		p, err := NewProxy(c.network, i, n.hostport, n.listen)
+		if err != nil {
+			c.Close()
+			return errors.Wrap(err, "local cluster")
+		}
+		c.proxies = append(c.proxies, p)
+	}
+	return nil
+}
+
+func (c *LocalCluster) clusterStart(args []string) error {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return err
+	}
+	for _, n := range c.nodes {
+		if n.running() {
+			continue
+		}
+		if err := c.startNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LocalCluster) clusterKill(args []string) error {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return err
+	}
+	for _, n := range c.nodes {
+		if err := c.killNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LocalCluster) clusterRollingRestart(args []string) error {
+	interval, err := parseInterval(args)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range c.nodes {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		if err := c.killNode(n); err != nil {
+			return err
+		}
+		if err := c.startNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LocalCluster) kill(args []string) error {
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	for i := len(c.nodes) - 1; i >= 0 && count > 0; i-- {
+		if !c.nodes[i].running() {
+			continue
+		}
+		if err := c.killNode(c.nodes[i]); err != nil {
+			return err
+		}
+		count--
+	}
+	if count > 0 {
+		msg := fmt.Sprintf("%d fewer nodes running than requested to kill", count)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (c *LocalCluster) start(args []string) error {
+	count, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(c.nodes) && count > 0; i++ {
+		if c.nodes[i].running() {
+			continue
+		}
+		if err := c.startNode(c.nodes[i]); err != nil {
+			return err
+		}
+		count--
+	}
+	if count > 0 {
+		msg := fmt.Sprintf("%d fewer nodes stopped than requested to start", count)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// parseInterval parses the optional INTERVAL argument of the
+// cluster-rolling-restart command, which is zero when it is left out.
+func parseInterval(args []string) (time.Duration, error) {
+	if err := expectArgs(args, 0, 1); err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, errors.Wrap(err, "rolling restart interval")
+	}
+	return interval, nil
+}
+
+// parseCount parses the single COUNT argument of the kill and start
+// commands.
+func parseCount(args []string) (int, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil {
+		msg := fmt.Sprintf("count %s is not an integer", args[0])
+		return 0, errors.New(msg)
+	}
+	if count < 0 {
+		msg := fmt.Sprintf("count %s is negative", args[0])
+		return 0, errors.New(msg)
+	}
+	return count, nil
+}
+
+// startNode starts the process of a node. The output of the process is
+// appended to a log file in Dir.
+func (c *LocalCluster) startNode(n *localNode) error {
+	logPath := filepath.Join(c.Dir, "node-"+strings.Replace(n.hostport, ":", "-", -1)+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "start node %s", n.hostport)
+	}
+
+	cmd := exec.Command(c.Binary, c.nodeArgs(n)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err = cmd.Start()
+	if err != nil {
+		logFile.Close()
+		return errors.Wrapf(err, "start node %s", n.hostport)
+	}
+
+	n.cmd = cmd
+	n.exited = make(chan struct{})
+	go func(exited chan struct{}) {
+		cmd.Wait()
+		logFile.Close()
+		close(exited)
+	}(n.exited)
+
+	return nil
+}
+
+// killNode kills the process of a node and waits for it to exit.
+func (c *LocalCluster) killNode(n *localNode) error {
+	if !n.running() {
+		return nil
+	}
+
+	err := n.cmd.Process.Kill()
+	if err != nil {
+		return errors.Wrapf(err, "kill node %s", n.hostport)
+	}
+	<-n.exited
+
+	return nil
+}
+
+// checkProxyArgs returns an error when the arguments of the nodes don't route
+// the traffic through the proxies. A node that doesn't advertise <HOSTPORT> is
+// contacted on the port it listens on, which bypasses the proxy so that the
+// network commands silently have no effect.
+func checkProxyArgs(args []string) error {
+	if len(args) == 0 {
+		args = defaultNodeArgs
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "<LISTEN>") || !strings.Contains(joined, "<HOSTPORT>") {
+		msg := fmt.Sprintf("the proxy requires nodes that listen on <LISTEN> and advertise <HOSTPORT>, args are \"%s\"", joined)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// nodeArgs returns the command-line arguments of a node with the variables
+// replaced.
+func (c *LocalCluster) nodeArgs(n *localNode) []string {
+	args := c.Args
+	if len(args) == 0 {
+		args = defaultNodeArgs
+	}
+
+	vars := strings.NewReplacer(
+		"<LISTEN>", n.listen,
+		"<HOSTPORT>", n.hostport,
+		"<HOSTS>", c.hostsFile(),
+		"<STATS>", c.StatsAddr,
+	)
+	result := make([]string, len(args))
+	for i := range args {
+		result[i] = vars.Replace(args[i])
+	}
+	return result
+}
+
+// hostsFile returns the path to the json file containing the bootstrap hosts.
+func (c *LocalCluster) hostsFile() string {
+	return filepath.Join(c.Dir, "hosts.json")
+}
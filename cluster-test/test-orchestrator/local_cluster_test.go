@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func ExampleLocalCluster() {
+	dir, _ := ioutil.TempDir("", "local-cluster")
+	defer os.RemoveAll(dir)
+
+	c, _ := NewLocalCluster(LocalCluster{
+		Binary:   "sleep",
+		Args:     []string{"10"},
+		Host:     "127.0.0.1",
+		BasePort: 3000,
+		Dir:      dir,
+	}, 3)
+	defer c.Close()
+
+	hs := c.Handlers()
+	fmt.Println(hs.Execute(&Command{Cmd: "cluster-start"}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "kill", Args: []string{"2"}}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "start", Args: []string{"1"}}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "kill", Args: []string{"3"}}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "cluster-rolling-restart"}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "cluster-kill"}), c.Hosts())
+	fmt.Println(hs.Execute(&Command{Cmd: "no-such-command"}))
+	fmt.Println(hs.Execute(&Command{Cmd: "kill", Args: []string{"-1"}}))
+	fmt.Println(hs.Execute(&Command{Cmd: "start", Args: []string{"one"}}))
+
+	// Output:
+	// <nil> [127.0.0.1:3000 127.0.0.1:3001 127.0.0.1:3002]
+	// <nil> [127.0.0.1:3000]
+	// <nil> [127.0.0.1:3000 127.0.0.1:3001]
+	// 1 fewer nodes running than requested to kill []
+	// <nil> [127.0.0.1:3000 127.0.0.1:3001 127.0.0.1:3002]
+	// <nil> []
+	// unsupported command no-such-command
+	// count -1 is negative
+	// count one is not an integer
+}
+
+// The proxies only see the traffic when the nodes advertise <HOSTPORT>.
+func ExampleNewLocalCluster_proxy() {
+	dir, _ := ioutil.TempDir("", "local-cluster")
+	defer os.RemoveAll(dir)
+
+	_, err := NewLocalCluster(LocalCluster{
+		Binary:   "sleep",
+		Host:     "127.0.0.1",
+		BasePort: 3000,
+		Dir:      dir,
+		Proxy:    true,
+	}, 2)
+	fmt.Println(err)
+
+	// Output:
+	// local cluster: the proxy requires nodes that listen on <LISTEN> and advertise <HOSTPORT>, args are "--listen=<LISTEN> --hosts=<HOSTS>"
+}
+
+// The proxies are stopped when the cluster can't be created.
+func ExampleNewLocalCluster_proxyCleanup() {
+	dir, _ := ioutil.TempDir("", "local-cluster")
+	defer os.RemoveAll(dir)
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	_, err := NewLocalCluster(LocalCluster{
+		Binary:   "sleep",
+		Args:     []string{"--listen=<LISTEN>", "--hostport=<HOSTPORT>"},
+		Host:     "127.0.0.1",
+		BasePort: port,
+		Dir:      filepath.Join(dir, "missing"),
+		Proxy:    true,
+	}, 1)
+	fmt.Println(err != nil)
+
+	l, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	fmt.Println(err)
+	if err == nil {
+		l.Close()
+	}
+
+	// Output:
+	// true
+	// <nil>
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The test-orchestrator runs the cluster-tests that are declared in a test
+// yaml file. Usage:
+//
+//     test-orchestrator [flags] <test.yaml>
+//
+// Every scenario is run in order and the result of every measurement is
+// reported. With the -lint flag the test yaml is only checked for mistakes.
+// The exit code is non-zero when a scenario or a measurement fails.
+// The settings in the config section of the test yaml can be overridden by
+// the flags.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	lintFlag = flag.Bool("lint", false, "check the test yaml for mistakes without running it")
+
+	baselineFlag       = flag.String("baseline", "", "yaml file with the values of an earlier run that assertions like \"within 15% of baseline\" compare with")
+	updateBaselineFlag = flag.Bool("update-baseline", false, "write the values measured in this run to the -baseline file")
+
+	binaryFlag        = flag.String("binary", "", "path to the ringpop binary that is tested")
+	argsFlag          = flag.String("args", "", "space separated arguments of the ringpop binary, may contain <LISTEN>, <HOSTPORT>, <HOSTS> and <STATS> (default \"--listen=<LISTEN> --hosts=<HOSTS>\")")
+	proxyFlag         = flag.Bool("proxy", false, "front every node with a fault-injection proxy, required by the network commands, the -args must contain <LISTEN> and <HOSTPORT>")
+	hostFlag          = flag.String("host", "", "host the ringpop nodes listen on (default 127.0.0.1)")
+	basePortFlag      = flag.String("base-port", "", "port of the first ringpop node (default 3000)")
+	statsPortFlag     = flag.String("stats-port", "", "udp port on which the ringpop stats are received (default 3300)")
+	statsDirFlag      = flag.String("stats-dir", "", "directory the stats files and node logs are written to (default .)")
+	statsPrefixFlag   = flag.String("stats-prefix", "", "first element of the path of every stat (default ringpop)")
+	stableForFlag     = flag.String("stable-for", "", "duration the cluster must stay stable before wait-for-stable returns (default 0s)")
+	silentAfterFlag   = flag.String("silent-after", "", "duration after which a live node that emits no stats keeps the cluster from being stable, 0 disables (default 5s)")
+	stableTimeoutFlag = flag.String("stable-timeout", "", "time wait-for-stable waits for the cluster to become stable, 0 waits forever (default 5m)")
+	abortFlag         = flag.Bool("abort-on-failure", false, "stop the script and skip the remaining measurements of a scenario when a required measurement fails")
+)
+
+// flagConfig returns the settings of the flags that are set on the command
+// line.
+func flagConfig() configYaml {
+	var c configYaml
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "binary":
+			c.Binary = *binaryFlag
+		case "args":
+			c.Args = strings.Fields(*argsFlag)
+		case "proxy":
+			c.Proxy = proxyFlag
+		case "host":
+			c.Host = *hostFlag
+		case "base-port":
+			c.BasePort = *basePortFlag
+		case "stats-port":
+			c.StatsPort = *statsPortFlag
+		case "stats-dir":
+			c.StatsDir = *statsDirFlag
+		case "stats-prefix":
+			c.StatsPrefix = *statsPrefixFlag
+		case "stable-for":
+			c.StableFor = *stableForFlag
+		case "silent-after":
+			c.SilentAfter = *silentAfterFlag
+		case "stable-timeout":
+			c.StableTimeout = *stableTimeoutFlag
+		case "abort-on-failure":
+			c.AbortOnFailure = abortFlag
+		}
+	})
+	return c
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <test.yaml>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	bts, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// the flags take precedence over the config in the test yaml
+	flags := flagConfig()
+	if _, err := defaultConfig().merge(flags); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *lintFlag {
+		if err := lintFile(flag.Arg(0), bts, flags); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	scns, err := parseFile(flag.Arg(0), bts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, scn := range scns {
+		scn.Config, err = scn.Config.merge(flags)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if scn.Config.Binary == "" {
+			log.Fatalf("no ringpop binary configured for scenario %s", scn.Name)
+		}
+	}
+
+	if *updateBaselineFlag && *baselineFlag == "" {
+		log.Fatalln("-update-baseline requires a -baseline file")
+	}
+	var baseline *Baseline
+	if *baselineFlag != "" {
+		baseline, err = LoadBaseline(*baselineFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	runner := &Runner{
+		Baseline:       baseline,
+		UpdateBaseline: *updateBaselineFlag,
+		NewExecutor: func(config *Config, size int) (Executor, error) {
+			return NewLocalCluster(LocalCluster{
+				Binary:    config.Binary,
+				Args:      config.Args,
+				Host:      config.Host,
+				BasePort:  config.BasePort,
+				StatsAddr: config.StatsAddr(),
+				Dir:       config.StatsDir,
+				Proxy:     config.Proxy,
+			}, size)
+		},
+	}
+
+	// only failed measurements that are required to pass fail the test,
+	// the others result in warnings
+	failed, warnings := false, 0
+	for i, scn := range scns {
+		fmt.Printf("scenario %s: %s\n", scn.Name, scn.Desc)
+		results, err := runner.Run(scn, i)
+		if err != nil {
+			fmt.Printf("ERROR %v\n", err)
+			failed = true
+			continue
+		}
+
+		for _, r := range results {
+			fmt.Println(r)
+			if r.Failed() {
+				failed = true
+			}
+			if r.Warned() {
+				warnings++
+			}
+		}
+		if *updateBaselineFlag {
+			baseline.Update(scn, results)
+		}
+	}
+
+	if *updateBaselineFlag {
+		if err := baseline.Save(*baselineFlag); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("updated baseline %s\n", *baselineFlag)
+	}
+
+	if warnings > 0 {
+		fmt.Printf("%d measurements only expected to pass failed\n", warnings)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -22,7 +22,9 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -42,30 +44,108 @@ type Measurement struct {
 	// Commands of the script.
 	Start, End string
 
-	// One of count, convtime or checksums.
+	// One of count, lines, convtime, checksums or silent-nodes.
 	Quantity string
 
-	// Currently only count accepts an argument, which is the statpath of
-	// the stats we want to count.
+	// count accepts an argument, which is the statpath or glob of the
+	// counters we want to sum. lines accepts an argument, a regular
+	// expression that matches the end of the statpath of the lines we want
+	// to count. silent-nodes accepts an optional argument, the duration
+	// after which a node that emits no stats is silent, which defaults to
+	// the SilentAfter of the Config.
 	Args []string
 
 	// The expected result of this measurement.
 	Assertion *Assertion
+
+	// Severity determines whether a failed assertion fails the test or only
+	// results in a warning.
+	Severity Severity
+
+	// Iterations is the number of iterations of a repeat block the
+	// Measurement is made for. Start and End then contain a "*" that stands
+	// for the iteration, e.g. "cycle.*.kill". Zero when the Measurement is
+	// made once.
+	Iterations int
+
+	// PerNode is true when the quantity is measured for every node, the
+	// Value is then NodeValues unless the Aggregate reduces it to a single
+	// Value. Aggregate is one of min, max, mean, stddev or spread, or empty.
+	PerNode   bool
+	Aggregate string
+}
+
+// Severity is the severity of a failed assertion of a Measurement.
+type Severity string
+
+const (
+	// SeverityRequire fails the test when the assertion fails, this is the
+	// default when a measure line declares no severity.
+	SeverityRequire Severity = "require"
+
+	// SeverityExpect only results in a warning when the assertion fails,
+	// e.g. for measurements that are informational.
+	SeverityExpect Severity = "expect"
+)
+
+// Iteration returns the Measurement of a single iteration, starting at 1.
+func (m *Measurement) Iteration(i int) *Measurement {
+	iteration := *m
+	iteration.Start = strings.Replace(m.Start, "*", strconv.Itoa(i), -1)
+	iteration.End = strings.Replace(m.End, "*", strconv.Itoa(i), -1)
+	iteration.Iterations = 0
+	return &iteration
+}
+
+// lastEnd returns the label after which the stats of the Measurement are
+// complete, the end label of its last iteration when it is made for the
+// iterations of a repeat block.
+func (m *Measurement) lastEnd() string {
+	if m.Iterations > 0 {
+		return m.Iteration(m.Iterations).End
+	}
+	return m.End
 }
 
 // String converts the Measurement into a string.
 func (m *Measurement) String() string {
-	strs := []string{m.Quantity}
-	strs = append(strs, m.Args...)
+	str := m.quantityString()
 	if m.Assertion != nil {
-		strs = append(strs, m.Assertion.String())
+		str += " " + m.Assertion.String()
+	}
+	return str
+}
+
+// quantityString returns what is measured without the labels and the
+// assertion, e.g. "per-node count ping.send spread".
+func (m *Measurement) quantityString() string {
+	var strs []string
+	if m.PerNode {
+		strs = append(strs, "per-node")
+	}
+	strs = append(strs, m.Quantity)
+	strs = append(strs, m.Args...)
+	if m.Aggregate != "" {
+		strs = append(strs, m.Aggregate)
 	}
 	return strings.Join(strs, " ")
 }
 
 // Measure performs the measurement and returns the resulting value on stats
-// that are extracted from the given Scanner.
-func (m *Measurement) Measure(s Scanner) (Value, error) {
+// that are extracted from the given Scanner. The config determines how the
+// stats are interpreted, when nil the default config is used.
+func (m *Measurement) Measure(s Scanner, config *Config) (Value, error) {
+	if config == nil {
+		config = defaultConfig()
+	}
+	if m.PerNode {
+		nodes, err := m.MeasureNodes(s, config)
+		if err != nil {
+			return nil, err
+		}
+		return nodes.Aggregate(m.Aggregate)
+	}
+
 	// select stats window we want to to measure on
 	var err error
 	s, err = NewSectionScanner(s, m.Start, m.End)
@@ -74,13 +154,13 @@ func (m *Measurement) Measure(s Scanner) (Value, error) {
 	}
 	switch m.Quantity {
 	case "convtime":
-		convtime, err := ConvergenceTimeAnalysis(s)
+		convtime, err := ConvergenceTimeAnalysis(s, config.StatsPrefix)
 		if err != nil {
 			return nil, errors.Wrapf(err, "measure %s\n", m)
 		}
 		return convtime, nil
 	case "checksums":
-		csums, err := ChecksumsAnalysis(s)
+		csums, err := ChecksumsAnalysis(s, config.StatsPrefix)
 		if err != nil {
 			return nil, errors.Wrapf(err, "measure %s\n", m)
 		}
@@ -91,11 +171,44 @@ func (m *Measurement) Measure(s Scanner) (Value, error) {
 			return nil, errors.New(msg)
 		}
 		statpath := m.Args[0]
-		count, err := CountAnalysis(s, statpath)
+		count, err := CountAnalysis(s, config.StatsPrefix, statpath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "measure %s\n", m)
+		}
+		return count, nil
+	case "lines":
+		if len(m.Args) != 1 {
+			msg := fmt.Sprintf("lines expects one argument, has %v", m.Args)
+			return nil, errors.New(msg)
+		}
+		lines, err := LinesAnalysis(s, config.StatsPrefix, m.Args[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "measure %s\n", m)
+		}
+		return float64(lines), nil
+	case "silent-nodes":
+		if len(m.Args) > 1 {
+			msg := fmt.Sprintf("silent-nodes expects at most one argument, has %v", m.Args)
+			return nil, errors.New(msg)
+		}
+		// without an argument nodes are silent when the StatIngester
+		// considers them silent
+		threshold := config.SilentAfter
+		if len(m.Args) == 1 {
+			threshold, err = time.ParseDuration(m.Args[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "measure %s\n", m)
+			}
+		}
+		if threshold == 0 {
+			msg := fmt.Sprintf("silent-nodes expects a duration argument when silent-after is 0, has %v", m.Args)
+			return nil, errors.New(msg)
+		}
+		silent, err := SilentNodesAnalysis(s, config.StatsPrefix, threshold)
 		if err != nil {
 			return nil, errors.Wrapf(err, "measure %s\n", m)
 		}
-		return float64(count), nil
+		return float64(silent), nil
 	}
 
 	msg := fmt.Sprintf("no such quantity: %s", m.Quantity)
@@ -0,0 +1,386 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the Network which holds the fault-injection rules that
+// the proxies in front of the nodes apply to the traffic between nodes. The
+// rules are applied per directed pair of nodes and are changed live by the
+// network-drop, network-delay, network-partition and network-heal commands.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A link is the directed connection between two nodes, identified by their
+// index in the cluster.
+type link struct {
+	from, to int
+}
+
+// linkRule describes how traffic over a link is degraded.
+type linkRule struct {
+	// The probability in [0, 1] that a frame is dropped.
+	drop float64
+
+	// The time a frame is held back before it is forwarded.
+	delay time.Duration
+
+	// When partitioned, all frames are dropped.
+	partitioned bool
+}
+
+// dropFrame decides whether a frame that is sent over the link is dropped.
+func (r linkRule) dropFrame() bool {
+	return r.partitioned || rand.Float64() < r.drop
+}
+
+// A Network keeps track of the nodes of a cluster and the rules that apply to
+// the traffic between them. It is safe for concurrent use.
+type Network struct {
+	sync.RWMutex
+
+	// The number of nodes in the cluster.
+	size int
+
+	// nodes maps the hostports a node is known by to its index.
+	nodes map[string]int
+
+	rules map[link]linkRule
+}
+
+// NewNetwork creates a Network for a cluster of the given size without any
+// rules, all traffic passes unaltered.
+func NewNetwork(size int) *Network {
+	return &Network{
+		size:  size,
+		nodes: make(map[string]int),
+		rules: make(map[link]linkRule),
+	}
+}
+
+// AddNode registers a hostport of the node with the given index. A node can
+// be known by multiple hostports, for example the address it advertises and
+// the address it listens on.
+func (n *Network) AddNode(hostport string, ix int) {
+	n.Lock()
+	defer n.Unlock()
+	n.nodes[hostport] = ix
+}
+
+// lookup returns the index of the node with the given hostport, or -1 if the
+// hostport is unknown.
+func (n *Network) lookup(hostport string) int {
+	n.RLock()
+	defer n.RUnlock()
+	if ix, ok := n.nodes[hostport]; ok {
+		return ix
+	}
+	return -1
+}
+
+// rule returns the rule that applies to the traffic from one node to
+// another.
+func (n *Network) rule(from, to int) linkRule {
+	n.RLock()
+	defer n.RUnlock()
+	return n.rules[link{from, to}]
+}
+
+// update applies f to the rules of the links.
+func (n *Network) update(links []link, f func(r *linkRule)) {
+	n.Lock()
+	defer n.Unlock()
+	n.updateLocked(links, f)
+}
+
+// updateLocked is update for a Network that is already locked.
+func (n *Network) updateLocked(links []link, f func(r *linkRule)) {
+	for _, l := range links {
+		r := n.rules[l]
+		f(&r)
+		if r == (linkRule{}) {
+			delete(n.rules, l)
+			continue
+		}
+		n.rules[l] = r
+	}
+}
+
+// Handlers returns the commands that change the rules of the Network:
+//
+// - `network-drop <SPLIT> <PERCENTAGE>` drops PERCENTAGE of the frames
+// - `network-delay <SPLIT> <DURATION>` delays the frames by DURATION
+// - `network-partition <GROUPS>` cuts the links between the groups
+// - `network-heal` restores all links cut by network-partition
+//
+// See parseSplit for the SPLIT syntax. A percentage or duration of 0 removes
+// the degradation. The GROUPS are node lists separated by `|`, e.g.
+// `0-4,9|5-8`. Nodes that are in none of the groups stay connected to all
+// nodes. A partition replaces the previous partition.
+func (n *Network) Handlers() CommandHandlers {
+	return CommandHandlers{
+		"network-drop":      n.networkDrop,
+		"network-delay":     n.networkDelay,
+		"network-partition": n.networkPartition,
+		"network-heal":      n.networkHeal,
+	}
+}
+
+func (n *Network) networkDrop(args []string) error {
+	links, drop, err := parseDropArgs(args, n.size)
+	if err != nil {
+		return err
+	}
+
+	n.update(links, func(r *linkRule) { r.drop = drop })
+	return nil
+}
+
+func (n *Network) networkDelay(args []string) error {
+	links, delay, err := parseDelayArgs(args, n.size)
+	if err != nil {
+		return err
+	}
+
+	n.update(links, func(r *linkRule) { r.delay = delay })
+	return nil
+}
+
+func (n *Network) networkPartition(args []string) error {
+	links, err := parsePartitionArgs(args, n.size)
+	if err != nil {
+		return err
+	}
+
+	// the previous partition is replaced at once, so that no traffic
+	// passes in between
+	n.Lock()
+	defer n.Unlock()
+	n.healLocked()
+	n.updateLocked(links, func(r *linkRule) { r.partitioned = true })
+	return nil
+}
+
+func (n *Network) networkHeal(args []string) error {
+	if err := expectArgs(args, 0, 0); err != nil {
+		return err
+	}
+	n.heal()
+	return nil
+}
+
+// heal restores all links that are cut by a partition.
+func (n *Network) heal() {
+	n.Lock()
+	defer n.Unlock()
+	n.healLocked()
+}
+
+// healLocked is heal for a Network that is already locked.
+func (n *Network) healLocked() {
+	var links []link
+	for l, r := range n.rules {
+		if r.partitioned {
+			links = append(links, l)
+		}
+	}
+	n.updateLocked(links, func(r *linkRule) { r.partitioned = false })
+}
+
+// parseDropArgs parses the SPLIT and PERCENTAGE arguments of the network-drop
+// command for a cluster of the given size.
+func parseDropArgs(args []string, size int) ([]link, float64, error) {
+	if err := expectArgs(args, 2, 2); err != nil {
+		return nil, 0, err
+	}
+	links, err := parseSplit(args[0], size)
+	if err != nil {
+		return nil, 0, err
+	}
+	drop, err := parsePercentage(args[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	return links, drop, nil
+}
+
+// parseDelayArgs parses the SPLIT and DURATION arguments of the
+// network-delay command for a cluster of the given size.
+func parseDelayArgs(args []string, size int) ([]link, time.Duration, error) {
+	if err := expectArgs(args, 2, 2); err != nil {
+		return nil, 0, err
+	}
+	links, err := parseSplit(args[0], size)
+	if err != nil {
+		return nil, 0, err
+	}
+	delay, err := time.ParseDuration(args[1])
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "delay")
+	}
+	if delay < 0 {
+		msg := fmt.Sprintf("delay %s is negative", args[1])
+		return nil, 0, errors.New(msg)
+	}
+	return links, delay, nil
+}
+
+// parsePartitionArgs parses the GROUPS argument of the network-partition
+// command for a cluster of the given size.
+func parsePartitionArgs(args []string, size int) ([]link, error) {
+	if err := expectArgs(args, 1, 1); err != nil {
+		return nil, err
+	}
+	return parsePartition(args[0], size)
+}
+
+// parsePartition parses the groups of a partition, e.g. `0-4,9|5-8`, and
+// returns the links between nodes of different groups.
+func parsePartition(str string, size int) ([]link, error) {
+	var groups [][]int
+	seen := make(map[int]bool)
+	for _, groupStr := range strings.Split(str, "|") {
+		group, err := parseNodes(groupStr, size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "partition %s", str)
+		}
+		for _, node := range group {
+			if seen[node] {
+				msg := fmt.Sprintf("partition %s: node %d is in multiple groups", str, node)
+				return nil, errors.New(msg)
+			}
+			seen[node] = true
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) < 2 {
+		msg := fmt.Sprintf("partition %s should contain at least two groups", str)
+		return nil, errors.New(msg)
+	}
+
+	var links []link
+	for i, as := range groups {
+		for j, bs := range groups {
+			if i == j {
+				continue
+			}
+			for _, a := range as {
+				for _, b := range bs {
+					links = append(links, link{a, b})
+				}
+			}
+		}
+	}
+	return links, nil
+}
+
+// parseSplit parses the links a network command applies to. The split is
+// either `A>B`, the links from every node in A to every node in B, or `A|B`,
+// the links in both directions. A and B are node lists as parsed by
+// parseNodes, e.g. `0-4,9|5-8` or `0>*`.
+func parseSplit(split string, size int) ([]link, error) {
+	sep := ">"
+	if strings.Contains(split, "|") {
+		sep = "|"
+	}
+	parts := strings.Split(split, sep)
+	if len(parts) != 2 {
+		msg := fmt.Sprintf("split %s should be of the form A>B or A|B", split)
+		return nil, errors.New(msg)
+	}
+
+	as, err := parseNodes(parts[0], size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "split %s", split)
+	}
+	bs, err := parseNodes(parts[1], size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "split %s", split)
+	}
+
+	var links []link
+	for _, a := range as {
+		for _, b := range bs {
+			if a == b {
+				continue
+			}
+			links = append(links, link{a, b})
+			if sep == "|" {
+				links = append(links, link{b, a})
+			}
+		}
+	}
+	return links, nil
+}
+
+// parseNodes parses a comma separated list of node indices and index ranges,
+// e.g. `0-4,9`. The list `*` contains all nodes.
+func parseNodes(str string, size int) ([]int, error) {
+	var nodes []int
+	if str == "*" {
+		for i := 0; i < size; i++ {
+			nodes = append(nodes, i)
+		}
+		return nodes, nil
+	}
+
+	for _, rng := range strings.Split(str, ",") {
+		bounds := strings.SplitN(rng, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			msg := fmt.Sprintf("node %s is not an index", bounds[0])
+			return nil, errors.New(msg)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				msg := fmt.Sprintf("node %s is not an index", bounds[1])
+				return nil, errors.New(msg)
+			}
+		}
+		if from < 0 || to >= size || from > to {
+			msg := fmt.Sprintf("node range %s out of bounds for cluster size %d", rng, size)
+			return nil, errors.New(msg)
+		}
+		for i := from; i <= to; i++ {
+			nodes = append(nodes, i)
+		}
+	}
+	return nodes, nil
+}
+
+// parsePercentage parses a percentage like `20` or `20%` into a fraction.
+func parsePercentage(str string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(str, "%"), 64)
+	if err != nil || f < 0 || f > 100 {
+		msg := fmt.Sprintf("percentage %s not in [0, 100]", str)
+		return 0, errors.New(msg)
+	}
+	return f / 100, nil
+}
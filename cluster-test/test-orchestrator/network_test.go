@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+func Example_parseSplit() {
+	fmt.Println(parseSplit("0-1,3>2", 4))
+	fmt.Println(parseSplit("0|1-2", 4))
+	fmt.Println(parseSplit("0>*", 3))
+	fmt.Println(parseSplit("0-4", 4))
+	fmt.Println(parseSplit("0>4", 4))
+	fmt.Println(parsePercentage("20%"))
+	fmt.Println(parsePercentage("120"))
+
+	// Output:
+	// [{0 2} {1 2} {3 2}] <nil>
+	// [{0 1} {1 0} {0 2} {2 0}] <nil>
+	// [{0 1} {0 2}] <nil>
+	// [] split 0-4 should be of the form A>B or A|B
+	// [] split 0>4: node range 4 out of bounds for cluster size 4
+	// 0.2 <nil>
+	// 0 percentage 120 not in [0, 100]
+}
+
+func ExampleNetwork() {
+	network := NewNetwork(4)
+	hs := network.Handlers()
+	fmt.Println(hs.Execute(&Command{Cmd: "network-partition", Args: []string{"0-1|2"}}))
+	fmt.Println(network.rule(0, 2).partitioned, network.rule(2, 1).partitioned)
+	fmt.Println(network.rule(0, 1).partitioned, network.rule(3, 0).partitioned)
+
+	fmt.Println(hs.Execute(&Command{Cmd: "network-drop", Args: []string{"0|2", "50"}}))
+	fmt.Println(hs.Execute(&Command{Cmd: "network-heal"}))
+	fmt.Println(network.rule(0, 2).drop, network.rule(2, 1).partitioned)
+
+	// a partition replaces the previous one
+	fmt.Println(hs.Execute(&Command{Cmd: "network-partition", Args: []string{"0-1|2"}}))
+	fmt.Println(hs.Execute(&Command{Cmd: "network-partition", Args: []string{"0|1-2"}}))
+	fmt.Println(network.rule(0, 2).partitioned, network.rule(1, 2).partitioned, network.rule(0, 2).drop)
+
+	fmt.Println(hs.Execute(&Command{Cmd: "network-partition", Args: []string{"0-1"}}))
+	fmt.Println(hs.Execute(&Command{Cmd: "network-partition", Args: []string{"0-1|1-2"}}))
+
+	// Output:
+	// <nil>
+	// true true
+	// false false
+	// <nil>
+	// <nil>
+	// 0.5 false
+	// <nil>
+	// <nil>
+	// true false 0.5
+	// partition 0-1 should contain at least two groups
+	// partition 0-1|1-2: node 1 is in multiple groups
+}
+
+func ExampleProxy() {
+	// the node echoes every frame it receives
+	node, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer node.Close()
+	go func() {
+		c, _ := node.Accept()
+		for {
+			frame, err := readFrame(c)
+			if err != nil {
+				return
+			}
+			c.Write(frame)
+		}
+	}()
+
+	network := NewNetwork(2)
+	network.AddNode("127.0.0.1:3001", 1)
+	p, _ := NewProxy(network, 0, "127.0.0.1:0", node.Addr().String())
+	defer p.Close()
+
+	c, _ := net.Dial("tcp", p.listener.Addr().String())
+	defer c.Close()
+	send := func(frame []byte) {
+		c.Write(frame)
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		echo, err := readFrame(c)
+		if err != nil {
+			fmt.Println("dropped")
+			return
+		}
+		fmt.Printf("echoed frame type %#x\n", echo[2])
+	}
+
+	init := testFrame(frameTypeInitReq, 0, 0, 0, 1, 0, 9, 'h', 'o', 's', 't', '_', 'p', 'o', 'r', 't',
+		0, 14, '1', '2', '7', '.', '0', '.', '0', '.', '1', ':', '3', '0', '0', '1')
+	fmt.Println(initHostPort(init))
+	send(init)
+	send(testFrame(frameTypeCallReq))
+
+	network.Handlers().Execute(&Command{Cmd: "network-drop", Args: []string{"1>0", "100"}})
+	send(testFrame(frameTypeCallReq))
+
+	network.Handlers().Execute(&Command{Cmd: "network-drop", Args: []string{"1>0", "0"}})
+	send(testFrame(frameTypeCallReq))
+
+	// the continuation of a dropped call is dropped as well
+	network.Handlers().Execute(&Command{Cmd: "network-drop", Args: []string{"1>0", "100"}})
+	send(testFrame(frameTypeCallReq, frameFlagMoreFragments))
+	network.Handlers().Execute(&Command{Cmd: "network-drop", Args: []string{"1>0", "0"}})
+	send(testFrame(frameTypeCallReqCont, 0))
+	send(testFrame(frameTypeCallReq, frameFlagMoreFragments))
+	send(testFrame(frameTypeCallReqCont, 0))
+
+	// Output:
+	// 127.0.0.1:3001
+	// echoed frame type 0x1
+	// echoed frame type 0x3
+	// dropped
+	// echoed frame type 0x3
+	// dropped
+	// dropped
+	// echoed frame type 0x3
+	// echoed frame type 0x13
+}
+
+// testFrame creates a TChannel frame of the given type and payload.
+func testFrame(typ byte, payload ...byte) []byte {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(frame)))
+	frame[2] = typ
+	copy(frame[frameHeaderSize:], payload)
+	return frame
+}
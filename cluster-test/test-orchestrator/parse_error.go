@@ -0,0 +1,120 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A ParseError describes a mistake in the test yaml and where it was made.
+type ParseError struct {
+	// The scenario and the run (starting at 1) the error occurred in. The
+	// fields are empty when the error is not specific to a scenario or run.
+	Scenario string
+	Run      int
+
+	// The field of the scenario the error occurred in, e.g. "measure".
+	Field string
+
+	// The position of the offending text in the yaml. File is empty when
+	// the yaml was not read from a file and Column is zero when the column is
+	// not known.
+	File   string
+	Line   int
+	Column int
+
+	// The offending text after the variables of the run are substituted.
+	Text string
+
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	var context []string
+	if e.Scenario != "" {
+		context = append(context, fmt.Sprintf("scenario '%s'", e.Scenario))
+	}
+	if e.Run > 0 {
+		context = append(context, fmt.Sprintf("run %d", e.Run))
+	}
+	if e.Field != "" {
+		context = append(context, e.Field)
+	}
+	if e.Text != "" {
+		context = append(context, fmt.Sprintf("'%s'", e.Text))
+	}
+
+	str := e.Msg
+	if len(context) > 0 {
+		str = strings.Join(context, " ") + ": " + str
+	}
+
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		str = fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, str)
+	case e.Line > 0:
+		str = fmt.Sprintf("line %d: %s", e.Line, str)
+	}
+	if e.File != "" {
+		str = e.File + ": " + str
+	}
+	return str
+}
+
+// ParseErrors is the list of all errors found in a test yaml, it is returned
+// by parse.
+type ParseErrors []*ParseError
+
+func (es ParseErrors) Error() string {
+	strs := make([]string, len(es))
+	for i, e := range es {
+		strs[i] = e.Error()
+	}
+	return strings.Join(strs, "\n")
+}
+
+// yamlErrorRegex matches the position in the errors of the yaml package,
+// e.g. "yaml: line 3: mapping values are not allowed in this context".
+var yamlErrorRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlParseErrors converts an error of the yaml package into ParseErrors.
+func yamlParseErrors(file string, err error) ParseErrors {
+	msgs := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+
+	var errs ParseErrors
+	for _, msg := range msgs {
+		e := &ParseError{File: file, Msg: msg}
+		if m := yamlErrorRegex.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
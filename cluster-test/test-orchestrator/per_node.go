@@ -0,0 +1,298 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the per-node breakdown of measurements, e.g.
+// "t0 t1 per-node count ping.send spread <= 5", and the aggregates that
+// reduce the Values of the nodes to a single Value.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NodeValues holds the Value of a per-node Measurement for every node, keyed
+// by the hostport of the node in the form used in stat paths, e.g.
+// "172_18_24_220_3000".
+type NodeValues map[string]Value
+
+// String converts the NodeValues to a string that lists the nodes in order,
+// e.g. "{172_18_24_220_3000: 3, 172_18_24_220_3001: 5}".
+func (nv NodeValues) String() string {
+	strs := make([]string, 0, len(nv))
+	for _, h := range nv.hosts() {
+		strs = append(strs, fmt.Sprintf("%s: %v", h, nv[h]))
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
+
+// hosts returns the hostports of the nodes in order.
+func (nv NodeValues) hosts() []string {
+	hosts := make([]string, 0, len(nv))
+	for h := range nv {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// aggregates reduces the Values of the nodes to a single Value.
+var aggregates = map[string]func(fs []float64) float64{
+	"min":    minimum,
+	"max":    maximum,
+	"mean":   mean,
+	"stddev": stddev,
+	"spread": func(fs []float64) float64 {
+		return maximum(fs) - minimum(fs)
+	},
+}
+
+func minimum(fs []float64) float64 {
+	min := fs[0]
+	for _, f := range fs {
+		min = math.Min(min, f)
+	}
+	return min
+}
+
+func maximum(fs []float64) float64 {
+	max := fs[0]
+	for _, f := range fs {
+		max = math.Max(max, f)
+	}
+	return max
+}
+
+func mean(fs []float64) float64 {
+	var sum float64
+	for _, f := range fs {
+		sum += f
+	}
+	return sum / float64(len(fs))
+}
+
+// stddev returns the population standard deviation.
+func stddev(fs []float64) float64 {
+	m := mean(fs)
+	var sum float64
+	for _, f := range fs {
+		sum += (f - m) * (f - m)
+	}
+	return math.Sqrt(sum / float64(len(fs)))
+}
+
+// Aggregate reduces the NodeValues to a single Value with one of the
+// aggregates min, max, mean, stddev or spread, which is the difference
+// between max and min. The NodeValues are returned as is when the aggregate
+// is empty.
+func (nv NodeValues) Aggregate(aggregate string) (Value, error) {
+	if aggregate == "" {
+		return nv, nil
+	}
+	fn, ok := aggregates[aggregate]
+	if !ok {
+		msg := fmt.Sprintf("no such aggregate: %s", aggregate)
+		return nil, errors.New(msg)
+	}
+	if len(nv) == 0 {
+		msg := fmt.Sprintf("no node values to aggregate with %s", aggregate)
+		return nil, errors.New(msg)
+	}
+
+	// the Values of all nodes must be of the type of the first node
+	hosts := nv.hosts()
+	_, isDuration := nv[hosts[0]].(time.Duration)
+	fs := make([]float64, 0, len(nv))
+	for _, h := range hosts {
+		if _, ok := nv[h].(time.Duration); ok != isDuration {
+			msg := fmt.Sprintf("can't aggregate %v of %s with %v of %s", nv[hosts[0]], hosts[0], nv[h], h)
+			return nil, errors.New(msg)
+		}
+		fs = append(fs, toFloat64(nv[h]))
+	}
+	f := fn(fs)
+	if isDuration {
+		return time.Duration(f), nil
+	}
+	return f, nil
+}
+
+// Outliers returns the nodes that stand out for the aggregate: the nodes with
+// the lowest Value for min, the highest for max, both for spread and the
+// nodes that deviate more than one standard deviation from the mean for mean
+// and stddev.
+func (nv NodeValues) Outliers(aggregate string) []string {
+	if len(nv) == 0 {
+		return nil
+	}
+	fs := make([]float64, 0, len(nv))
+	for _, v := range nv {
+		fs = append(fs, toFloat64(v))
+	}
+	min, max := minimum(fs), maximum(fs)
+	m, sd := mean(fs), stddev(fs)
+
+	var outliers []string
+	for _, h := range nv.hosts() {
+		f := toFloat64(nv[h])
+		var outlier bool
+		switch aggregate {
+		case "min":
+			outlier = f == min
+		case "max":
+			outlier = f == max
+		case "spread":
+			outlier = f == min || f == max
+		default:
+			outlier = math.Abs(f-m) > sd
+		}
+		if outlier {
+			outliers = append(outliers, h)
+		}
+	}
+	return outliers
+}
+
+// outliersString lists the outliers with their Values, e.g.
+// "172_18_24_220_3000: 3, 172_18_24_220_3001: 9".
+func (nv NodeValues) outliersString(aggregate string) string {
+	var strs []string
+	for _, h := range nv.Outliers(aggregate) {
+		strs = append(strs, fmt.Sprintf("%s: %v", h, nv[h]))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// assertNodes checks the Assertion for the Value of every node and returns an
+// error that names the nodes for which it doesn't hold.
+func (a *Assertion) assertNodes(nodes NodeValues) error {
+	var failures []string
+	for _, h := range nodes.hosts() {
+		if err := a.Assert(nodes[h]); err != nil {
+			failures = append(failures, h+": "+failure(err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("FAILED assertion: %d of %d nodes failed: %s", len(failures), len(nodes), strings.Join(failures, "; "))
+	return errors.New(msg)
+}
+
+// perNodeQuantities are the quantities that can be measured per node.
+var perNodeQuantities = map[string]bool{
+	"count":     true,
+	"lines":     true,
+	"checksums": true,
+	"convtime":  true,
+}
+
+// checkPerNode returns an error when the Measurement can't be performed per
+// node. Checksums are hashes, so a per-node checksum can't be aggregated and
+// can only be compared for equality.
+func (m *Measurement) checkPerNode() error {
+	if !perNodeQuantities[m.Quantity] {
+		msg := fmt.Sprintf("%s can't be measured per node", m.Quantity)
+		return errors.New(msg)
+	}
+	if m.Quantity != "checksums" {
+		return nil
+	}
+	if m.Aggregate != "" {
+		msg := fmt.Sprintf("checksums per node can't be aggregated with %s", m.Aggregate)
+		return errors.New(msg)
+	}
+	if a := m.Assertion; a != nil && a.isNumeric() {
+		msg := fmt.Sprintf("checksums per node can't be compared with %s", a)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// isNumeric indicates whether the Assertion, or one of its operands, is a
+// comparison that only makes sense for numbers, e.g. < or ≈.
+func (a *Assertion) isNumeric() bool {
+	switch a.Type {
+	case AssertionTypeIs, AssertionTypeNotEqual:
+		return false
+	case AssertionTypeAnd, AssertionTypeOr, AssertionTypeNot:
+		for _, o := range a.Operands {
+			if o.isNumeric() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// MeasureNodes performs a per-node Measurement and returns the Value of
+// every node. For checksums the Value of a node is its last membership
+// checksum, for convtime the time between the first and the last membership
+// change it applied.
+func (m *Measurement) MeasureNodes(s Scanner, config *Config) (NodeValues, error) {
+	if err := m.checkPerNode(); err != nil {
+		return nil, err
+	}
+	if m.Quantity == "count" || m.Quantity == "lines" {
+		if len(m.Args) != 1 {
+			msg := fmt.Sprintf("%s expects one argument, has %v", m.Quantity, m.Args)
+			return nil, errors.New(msg)
+		}
+	}
+
+	s, err := NewSectionScanner(s, m.Start, m.End)
+	if err != nil {
+		return nil, errors.Wrapf(err, "measure %s\n", m)
+	}
+
+	nodes := make(NodeValues)
+	switch m.Quantity {
+	case "count", "lines", "checksums":
+		var fs map[string]float64
+		switch m.Quantity {
+		case "count":
+			fs, err = CountPerNodeAnalysis(s, config.StatsPrefix, m.Args[0])
+		case "lines":
+			fs, err = LinesPerNodeAnalysis(s, config.StatsPrefix, m.Args[0])
+		default:
+			fs, err = ChecksumsPerNodeAnalysis(s, config.StatsPrefix)
+		}
+		for h, f := range fs {
+			nodes[h] = f
+		}
+	case "convtime":
+		var ds map[string]time.Duration
+		ds, err = ConvergenceTimePerNodeAnalysis(s, config.StatsPrefix)
+		for h, d := range ds {
+			nodes[h] = d
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "measure %s\n", m)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+func ExampleNodeValues_Aggregate() {
+	nodes := NodeValues{"a_3000": 2.0, "b_3000": 4.0, "c_3000": 4.0, "d_3000": 6.0}
+	for _, aggregate := range []string{"min", "max", "mean", "stddev", "spread"} {
+		v, _ := nodes.Aggregate(aggregate)
+		fmt.Println(aggregate, v, nodes.Outliers(aggregate))
+	}
+
+	durations := NodeValues{"a_3000": time.Second, "b_3000": 3 * time.Second}
+	fmt.Println(durations.Aggregate("mean"))
+	fmt.Println(durations.Aggregate("median"))
+
+	mixed := NodeValues{"a_3000": time.Second, "b_3000": 3.0}
+	fmt.Println(mixed.Aggregate("max"))
+
+	// Output:
+	// min 2 [a_3000]
+	// max 6 [d_3000]
+	// mean 4 [a_3000 d_3000]
+	// stddev 1.4142135623730951 [a_3000 d_3000]
+	// spread 4 [a_3000 d_3000]
+	// 2s <nil>
+	// <nil> no such aggregate: median
+	// <nil> can't aggregate 1s of a_3000 with 3 of b_3000
+}
+
+func Example_measurePerNode() {
+	file, err := ioutil.TempFile("", "per-node")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(perNodeStats)
+	file.Close()
+
+	for _, str := range []string{
+		"t0 t1 per-node count ping.send",
+		"t0 t1 per-node count ping.send < 5",
+		"t0 t1 per-node count ping.send spread <= 3",
+		"t0 t1 per-node count ping.send mean in (2, 4)",
+		"t0 t1 per-node convtime max < 2s",
+		"t0 t1 per-node checksums",
+		"t0 t1 per-node silent-nodes",
+	} {
+		m, err := parseMeasurement(str)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(measure(m, defaultConfig(), file.Name(), nil))
+	}
+
+	// Output:
+	// PASS t0 t1 per-node count ping.send: {172_18_24_220_3000: 1, 172_18_24_220_3001: 2, 172_18_24_220_3002: 6}
+	// FAIL t0 t1 per-node count ping.send < 5: FAILED assertion: 1 of 3 nodes failed: 172_18_24_220_3002: expected < 5 got 6
+	// FAIL t0 t1 per-node count ping.send spread <= 3: FAILED assertion: expected <= 3 got 5, outliers 172_18_24_220_3000: 1, 172_18_24_220_3002: 6
+	// PASS t0 t1 per-node count ping.send mean in (2,4): 3
+	// FAIL t0 t1 per-node convtime max < 2s: FAILED assertion: expected < 2s got 3s, outliers 172_18_24_220_3001: 3s
+	// PASS t0 t1 per-node checksums: {172_18_24_220_3000: 1234, 172_18_24_220_3001: 4321}
+	// FAIL t0 t1 per-node silent-nodes: silent-nodes can't be measured per node
+}
+
+var perNodeStats = `label:t0|cmd: kill 1
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_220_3000.ping.send:1|c
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_220_3001.ping.send:2|c
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_220_3002.ping.send:1|c|@0.5
+2016-06-17T11:29:15.0Z|ringpop.172_18_24_220_3002.ping.send:4|c
+2016-06-17T11:29:16.0Z|ringpop.172_18_24_220_3000.membership-set.suspect:1|c
+2016-06-17T11:29:16.0Z|ringpop.172_18_24_220_3001.membership-set.suspect:1|c
+2016-06-17T11:29:17.0Z|ringpop.172_18_24_220_3000.membership-set.faulty:1|c
+2016-06-17T11:29:17.0Z|ringpop.172_18_24_220_3000.checksum:1234|g
+2016-06-17T11:29:19.0Z|ringpop.172_18_24_220_3001.membership-set.faulty:1|c
+2016-06-17T11:29:19.0Z|ringpop.172_18_24_220_3001.checksum:4321|g
+label:t1|cmd: wait-for-stable
+`
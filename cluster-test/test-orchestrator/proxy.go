@@ -0,0 +1,308 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the Proxy that sits in front of the listening port of a
+// node. Ringpop nodes talk TChannel to each other, the proxy forwards the
+// traffic frame by frame so that it can drop whole calls and delay frames
+// according to the rules of the Network without needing root privileges or a
+// firewall.
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// The size of the header of a TChannel frame.
+	frameHeaderSize = 16
+
+	frameTypeInitReq      = 0x01
+	frameTypeCallReq      = 0x03
+	frameTypeCallRes      = 0x04
+	frameTypeCallReqCont  = 0x13
+	frameTypeCallResCont  = 0x14
+	initHostPortHeaderKey = "host_port"
+
+	// The flag of a call frame that indicates that continuation frames
+	// follow.
+	frameFlagMoreFragments = 0x01
+)
+
+// A Proxy accepts connections on the hostport of a node and forwards them to
+// the hostport the node actually listens on. The source node of a connection
+// is identified by the host_port header of the TChannel init request.
+type Proxy struct {
+	network *Network
+
+	// The index of the node behind the proxy.
+	index int
+
+	// The hostport the node listens on.
+	target string
+
+	listener net.Listener
+
+	// Protects conns.
+	sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewProxy starts listening on addr and forwards connections to the node
+// with the given index that listens on target.
+func NewProxy(network *Network, index int, addr, target string) (*Proxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "proxy")
+	}
+
+	p := &Proxy{
+		network:  network,
+		index:    index,
+		target:   target,
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go p.serve()
+
+	return p, nil
+}
+
+// Close stops listening and closes all forwarded connections.
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+
+	p.Lock()
+	defer p.Unlock()
+	for c := range p.conns {
+		c.Close()
+	}
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (p *Proxy) serve() {
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(c)
+	}
+}
+
+// track adds or removes a connection from the connections that are closed
+// when the proxy is closed.
+func (p *Proxy) track(c net.Conn, add bool) {
+	p.Lock()
+	defer p.Unlock()
+	if add {
+		p.conns[c] = struct{}{}
+	} else {
+		delete(p.conns, c)
+	}
+}
+
+// handle forwards a single connection to the node. When the node is not
+// running the connection is closed, as if the node refused it.
+func (p *Proxy) handle(client net.Conn) {
+	p.track(client, true)
+	defer p.track(client, false)
+	defer client.Close()
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	p.track(server, true)
+	defer p.track(server, false)
+	defer server.Close()
+
+	// the first frame identifies the node that opened the connection
+	frame, err := readFrame(client)
+	if err != nil {
+		return
+	}
+	source := p.network.lookup(initHostPort(frame))
+	if _, err := server.Write(frame); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pipe(client, server, source, p.index)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pipe(server, client, p.index, source)
+		done <- struct{}{}
+	}()
+
+	// when one direction stops, close both so the other stops too
+	<-done
+	client.Close()
+	server.Close()
+	<-done
+}
+
+// delayedFrame is a frame that is forwarded when its time has come.
+type delayedFrame struct {
+	frame []byte
+	at    time.Time
+}
+
+// A message identifies the frames of a call request or response, which
+// consist of a call frame followed by continuation frames with the same id.
+type message struct {
+	res bool
+	id  uint32
+}
+
+// pipe forwards the frames read from src to dst, applying the rule of the
+// link between the two nodes. The order of the frames is preserved. Calls are
+// dropped as a whole, a message with some of its frames missing would corrupt
+// the connection.
+func (p *Proxy) pipe(src, dst net.Conn, from, to int) {
+	queue := make(chan delayedFrame, 1024)
+	go func() {
+		for f := range queue {
+			time.Sleep(f.at.Sub(time.Now()))
+			if _, err := dst.Write(f.frame); err != nil {
+				src.Close()
+			}
+		}
+	}()
+	defer close(queue)
+
+	// the messages that are being dropped and have more frames to come
+	dropping := make(map[message]bool)
+	for {
+		frame, err := readFrame(src)
+		if err != nil {
+			return
+		}
+
+		rule := p.network.rule(from, to)
+		if isCallFrame(frame) {
+			msg := frameMessage(frame)
+			drop := dropping[msg]
+			if frame[2] == frameTypeCallReq || frame[2] == frameTypeCallRes {
+				drop = rule.dropFrame()
+			}
+			if drop && moreFragments(frame) {
+				dropping[msg] = true
+			} else {
+				delete(dropping, msg)
+			}
+			if drop {
+				continue
+			}
+		}
+		queue <- delayedFrame{frame, time.Now().Add(rule.delay)}
+	}
+}
+
+// readFrame reads a single TChannel frame, header included.
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n < frameHeaderSize {
+		return nil, errors.New("frame smaller than its header")
+	}
+
+	frame := make([]byte, n)
+	copy(frame, size[:])
+	if _, err := io.ReadFull(r, frame[2:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// isCallFrame indicates whether the frame is part of a call. Only call frames
+// are dropped so that connections can still be established and kept alive.
+func isCallFrame(frame []byte) bool {
+	switch frame[2] {
+	case frameTypeCallReq, frameTypeCallRes, frameTypeCallReqCont, frameTypeCallResCont:
+		return true
+	}
+	return false
+}
+
+// frameMessage returns the message a call frame belongs to.
+func frameMessage(frame []byte) message {
+	res := frame[2] == frameTypeCallRes || frame[2] == frameTypeCallResCont
+	return message{res, binary.BigEndian.Uint32(frame[4:8])}
+}
+
+// moreFragments indicates whether more frames of the message of a call frame
+// follow, which is the first bit of the flags that start the payload.
+func moreFragments(frame []byte) bool {
+	return len(frame) > frameHeaderSize && frame[frameHeaderSize]&frameFlagMoreFragments != 0
+}
+
+// initHostPort returns the host_port header of an init request frame, or the
+// empty string when the frame is not an init request or lacks the header.
+func initHostPort(frame []byte) string {
+	if len(frame) < frameHeaderSize+4 || frame[2] != frameTypeInitReq {
+		return ""
+	}
+
+	// payload: version:2 nh:2 (key~2 value~2){nh}
+	payload := frame[frameHeaderSize+2:]
+	nh := int(binary.BigEndian.Uint16(payload))
+	payload = payload[2:]
+	for i := 0; i < nh; i++ {
+		key, rest, ok := readString2(payload)
+		if !ok {
+			return ""
+		}
+		value, rest, ok := readString2(rest)
+		if !ok {
+			return ""
+		}
+		if key == initHostPortHeaderKey {
+			return value
+		}
+		payload = rest
+	}
+	return ""
+}
+
+// readString2 reads a string prefixed by its 2 byte length.
+func readString2(b []byte) (s string, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
@@ -0,0 +1,458 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the Runner which runs a Scenario end to end. It records
+// the stats of the cluster while the script runs and afterwards performs the
+// measurements on the recorded stats.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Runner runs Scenarios against a ringpop cluster. The stats that the
+// cluster emits are written to a file per scenario, the measurements of the
+// scenario are performed on that file after the script has finished, or
+// when it is stopped, see Run.
+type Runner struct {
+	// NewExecutor creates the Executor that runs the commands of a scenario
+	// on a cluster of the given size.
+	NewExecutor func(config *Config, size int) (Executor, error)
+
+	// Baseline holds the Values that assertions of the form "within 15% of
+	// baseline" compare with, may be nil when there is no baseline.
+	Baseline *Baseline
+
+	// UpdateBaseline is set when the Values of the run become the baseline.
+	// The assertions that compare with a baseline that doesn't exist yet are
+	// then not evaluated instead of failing.
+	UpdateBaseline bool
+}
+
+// A Result is the outcome of a single Measurement of a Scenario.
+type Result struct {
+	Measurement *Measurement
+
+	// The measured Value, nil when the measurement itself failed.
+	Value Value
+
+	// Err is nil when the measurement succeeded and its assertion holds.
+	Err error
+
+	// The baseline the assertion compared with, nil when the assertion
+	// doesn't compare with a baseline.
+	Baseline Value
+
+	// Iterations holds the results of every iteration when the Measurement
+	// is made for the iterations of a repeat block. The Value is then the
+	// mean of the iterations.
+	Iterations []*Result
+
+	// Skipped is true when the measurement wasn't made because a required
+	// measurement of the scenario failed before it.
+	Skipped bool
+
+	// NoBaseline is true when the assertion wasn't evaluated because it
+	// compares with a baseline that doesn't exist yet, see
+	// Runner.UpdateBaseline. The Value is measured to become the baseline.
+	NoBaseline bool
+}
+
+// Passed indicates whether the measurement succeeded and its assertion held.
+func (r *Result) Passed() bool {
+	return r.Err == nil && !r.Skipped
+}
+
+// Failed indicates whether a measurement that is required to pass failed.
+func (r *Result) Failed() bool {
+	return !r.Passed() && !r.Skipped && r.Measurement.Severity != SeverityExpect
+}
+
+// Warned indicates whether a measurement that is only expected to pass
+// failed.
+func (r *Result) Warned() bool {
+	return !r.Passed() && !r.Skipped && r.Measurement.Severity == SeverityExpect
+}
+
+// String converts a Result to a string like "PASS t1 t2 convtime in (1s,2s): 1.2s".
+// The results of the iterations are listed on the lines that follow.
+func (r *Result) String() string {
+	var str string
+	switch {
+	case r.Skipped:
+		str = fmt.Sprintf("SKIP %s %s %s", r.Measurement.Start, r.Measurement.End, r.Measurement)
+	case r.NoBaseline:
+		str = fmt.Sprintf("NEW  %s %s %s: %v (no baseline yet)", r.Measurement.Start, r.Measurement.End, r.Measurement, r.Value)
+	case r.Warned():
+		str = fmt.Sprintf("WARN %s %s %s: %v", r.Measurement.Start, r.Measurement.End, r.Measurement, r.Err)
+	case !r.Passed():
+		str = fmt.Sprintf("FAIL %s %s %s: %v", r.Measurement.Start, r.Measurement.End, r.Measurement, r.Err)
+	case r.Iterations != nil:
+		min, max := valueRange(r.Iterations)
+		str = fmt.Sprintf("PASS %s %s %s: mean %v, min %v, max %v", r.Measurement.Start, r.Measurement.End, r.Measurement, r.Value, min, max)
+	default:
+		str = fmt.Sprintf("PASS %s %s %s: %v", r.Measurement.Start, r.Measurement.End, r.Measurement, r.Value)
+	}
+	if r.Baseline != nil {
+		str += fmt.Sprintf(" (baseline %v)", r.Baseline)
+	}
+
+	for _, it := range r.Iterations {
+		str += "\n  " + it.String()
+	}
+	return str
+}
+
+// Run runs the script of the scenario while recording the stats of the
+// cluster and then measures every Measurement of the scenario. The index
+// distinguishes the stats files of runs of scenarios that share a name. An
+// error is returned when the script could not be run; failed measurements are
+// reported through the results.
+//
+// When the scenario aborts on failure, a required measurement is already made
+// when the script reaches the label that ends it. The script stops when the
+// measurement fails: the command at that label and the commands after it
+// don't run, and the measurements that need them are skipped.
+func (r *Runner) Run(scn *Scenario, ix int) ([]*Result, error) {
+	statsPath := filepath.Join(scn.Config.StatsDir, statsFileName(scn, ix))
+	stopped, err := r.runScript(scn, statsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "run scenario %s\n", scn.Name)
+	}
+
+	return r.measureAll(scn, statsPath, stopped), nil
+}
+
+// measureAll performs the measurements of a scenario on the stats file. The
+// script stopped at the command with the label stopped, or ran to the end when
+// stopped is empty; the measurements that end after that label are skipped.
+// When the scenario aborts on failure, the measurements that follow a failed
+// required measurement are skipped as well.
+func (r *Runner) measureAll(scn *Scenario, statsPath, stopped string) []*Result {
+	reached := reachedLabels(scn.Script, stopped)
+	var results []*Result
+	aborted := false
+	for _, m := range scn.Measure {
+		if aborted || (reached != nil && !reached[m.lastEnd()]) {
+			results = append(results, &Result{Measurement: m, Skipped: true})
+			continue
+		}
+		result := r.measure(scn, m, statsPath)
+		results = append(results, result)
+		aborted = result.Failed() && scn.Config.AbortOnFailure
+	}
+	return results
+}
+
+// measure performs a single Measurement of the scenario and compares it with
+// its baseline.
+func (r *Runner) measure(scn *Scenario, m *Measurement, statsPath string) *Result {
+	baseline := r.Baseline.Value(scn, m)
+	if baseline == nil && r.UpdateBaseline && m.Assertion.usesBaseline() {
+		return measureWithoutBaseline(m, scn.Config, statsPath)
+	}
+	return measure(m, scn.Config, statsPath, baseline)
+}
+
+// abortAt indicates whether the script of a scenario that aborts on failure
+// stops at the command with the given label, because a required measurement
+// that ends at the label fails. The label is already in the stats file, so
+// the measurement can be made before the command runs.
+func (r *Runner) abortAt(scn *Scenario, statsPath, label string) bool {
+	if !scn.Config.AbortOnFailure {
+		return false
+	}
+	for _, m := range scn.Measure {
+		if m.Severity == SeverityExpect || m.lastEnd() != label {
+			continue
+		}
+		if r.measure(scn, m, statsPath).Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// reachedLabels returns the labels of the script up to and including the
+// label stopped, or nil when the script wasn't stopped.
+func reachedLabels(script []*Command, stopped string) map[string]bool {
+	if stopped == "" {
+		return nil
+	}
+	reached := map[string]bool{scriptStartLabel: true}
+	for _, cmd := range script {
+		reached[cmd.Label] = true
+		if cmd.Label == stopped {
+			break
+		}
+	}
+	return reached
+}
+
+// runScript runs the commands of the script of the scenario in order while
+// the stats are ingested and written to the stats file. It returns the label
+// of the command the script stopped at when the scenario aborts, see Run.
+func (r *Runner) runScript(scn *Scenario, statsPath string) (string, error) {
+	file, err := os.Create(statsPath)
+	if err != nil {
+		return "", errors.Wrap(err, "create stats file")
+	}
+	defer file.Close()
+
+	config := scn.Config
+	scanner, err := NewUDPScanner(strconv.Itoa(config.StatsPort))
+	if err != nil {
+		return "", err
+	}
+
+	// the scanner listens before the nodes start so that no stats are lost,
+	// it must be closed when the nodes can't be started
+	exe, err := r.NewExecutor(config, scn.Size)
+	if err != nil {
+		scanner.Close()
+		return "", errors.Wrap(err, "create executor")
+	}
+	defer exe.Close()
+
+	si := NewStatIngester(file, scn.Stable...)
+	si.Hold = config.StableFor
+	si.SilentAfter = config.SilentAfter
+	si.StatsPrefix = config.StatsPrefix
+	handlers := exe.Handlers().Merge(CommandHandlers{
+		"wait-for-stable": func(args []string) error {
+			return waitForStable(si, exe, config.StableTimeout, args)
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		si.IngestStats(scanner)
+		close(done)
+	}()
+	defer func() {
+		scanner.Close()
+		<-done
+	}()
+
+	for _, cmd := range scn.Script {
+		log.Printf("%s: %s", cmd.Label, cmd)
+		si.InsertLabel(cmd.Label, cmd.String())
+		if r.abortAt(scn, statsPath, cmd.Label) {
+			log.Printf("%s: abort, a required measurement failed", cmd.Label)
+			return cmd.Label, nil
+		}
+		err := handlers.Execute(cmd)
+		if err != nil {
+			return "", errors.Wrapf(err, "command %s: %s\n", cmd.Label, cmd)
+		}
+	}
+
+	return "", nil
+}
+
+// waitForStable implements `wait-for-stable [<TIMEOUT>]`. It waits for the
+// hosts of the executor to become stable and fails when that takes longer
+// than the timeout argument, or the given timeout when there is no argument.
+func waitForStable(si *StatIngester, exe Executor, timeout time.Duration, args []string) error {
+	timeout, err := parseTimeout(args, timeout)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return si.WaitForStableContext(ctx, exe.Hosts())
+}
+
+// parseTimeout parses the optional TIMEOUT argument of the wait-for-stable
+// command, the timeout is returned when the argument is left out.
+func parseTimeout(args []string, timeout time.Duration) (time.Duration, error) {
+	if err := expectArgs(args, 0, 1); err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		return timeout, nil
+	}
+	timeout, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, errors.Wrap(err, "wait-for-stable timeout")
+	}
+	return timeout, nil
+}
+
+// measure performs a Measurement on the stats file and asserts the result.
+// The baseline is the Value the assertion compares with when it refers to
+// the baseline, nil when there is none.
+func measure(m *Measurement, config *Config, statsPath string, baseline Value) *Result {
+	if m.Iterations > 0 {
+		return measureIterations(m, config, statsPath, baseline)
+	}
+
+	file, err := os.Open(statsPath)
+	if err != nil {
+		return &Result{Measurement: m, Err: errors.Wrap(err, "open stats file")}
+	}
+	defer file.Close()
+
+	var nodes NodeValues
+	var v Value
+	if m.PerNode {
+		nodes, err = m.MeasureNodes(bufio.NewScanner(file), config)
+		if err == nil {
+			v, err = nodes.Aggregate(m.Aggregate)
+		}
+	} else {
+		v, err = m.Measure(bufio.NewScanner(file), config)
+	}
+	if err != nil {
+		return &Result{Measurement: m, Err: err}
+	}
+
+	// the Value is kept when there is no baseline, so that it can become
+	// the baseline
+	assertion, err := m.Assertion.WithBaseline(baseline)
+	if err != nil {
+		return &Result{Measurement: m, Value: v, Err: err}
+	}
+	err = assertion.Assert(v)
+
+	// name the nodes that stand out when an aggregate fails
+	if err != nil && m.Aggregate != "" {
+		msg := fmt.Sprintf("%v, outliers %s", err, nodes.outliersString(m.Aggregate))
+		err = errors.New(msg)
+	}
+	return &Result{Measurement: m, Value: v, Err: err, Baseline: baselineOf(m, baseline)}
+}
+
+// measureWithoutBaseline performs a Measurement of which the assertion
+// compares with a baseline that doesn't exist yet. The Value is measured but
+// the assertion isn't evaluated.
+func measureWithoutBaseline(m *Measurement, config *Config, statsPath string) *Result {
+	unasserted := *m
+	unasserted.Assertion = nil
+	result := measure(&unasserted, config, statsPath, nil)
+	result.Measurement = m
+	result.NoBaseline = result.Err == nil
+	return result
+}
+
+// measureIterations performs a Measurement for every iteration of a repeat
+// block. The Value of the Result is the mean of the iterations and the Result
+// passes when every iteration passes.
+func measureIterations(m *Measurement, config *Config, statsPath string, baseline Value) *Result {
+	result := &Result{Measurement: m, Baseline: baselineOf(m, baseline)}
+	failed := 0
+	for i := 1; i <= m.Iterations; i++ {
+		it := measure(m.Iteration(i), config, statsPath, baseline)
+		result.Iterations = append(result.Iterations, it)
+		if !it.Passed() {
+			failed++
+		}
+	}
+
+	result.Value = meanValue(result.Iterations)
+	if failed > 0 {
+		msg := fmt.Sprintf("%d of %d iterations failed", failed, m.Iterations)
+		result.Err = errors.New(msg)
+	}
+	return result
+}
+
+// baselineOf returns the baseline when the assertion of the Measurement
+// compares with it and nil otherwise.
+func baselineOf(m *Measurement, baseline Value) Value {
+	if m.Assertion.usesBaseline() {
+		return baseline
+	}
+	return nil
+}
+
+// isScalar returns whether the Value is a number or a duration, as opposed
+// to the NodeValues of a per-node measurement.
+func isScalar(v Value) bool {
+	switch v.(type) {
+	case float64, time.Duration:
+		return true
+	}
+	return false
+}
+
+// meanValue returns the mean of the values of the results, the results
+// without a value are skipped.
+func meanValue(results []*Result) Value {
+	var sum float64
+	var n int
+	var v Value
+	for _, r := range results {
+		if isScalar(r.Value) {
+			sum += toFloat64(r.Value)
+			n++
+			v = r.Value
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, ok := v.(time.Duration); ok {
+		return time.Duration(sum / float64(n))
+	}
+	return sum / float64(n)
+}
+
+// valueRange returns the minimum and maximum value of the results, the
+// results without a value are skipped.
+func valueRange(results []*Result) (min, max Value) {
+	for _, r := range results {
+		if !isScalar(r.Value) {
+			continue
+		}
+		if min == nil || toFloat64(r.Value) < toFloat64(min) {
+			min = r.Value
+		}
+		if max == nil || toFloat64(r.Value) > toFloat64(max) {
+			max = r.Value
+		}
+	}
+	return min, max
+}
+
+var unsafeFileChars = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// statsFileName returns the name of the file the stats of a scenario are
+// written to, e.g. "03-kill-half-the-cluster.stats".
+func statsFileName(scn *Scenario, ix int) string {
+	return fmt.Sprintf("%02d-%s.stats", ix, unsafeFileChars.ReplaceAllString(scn.Name, "-"))
+}
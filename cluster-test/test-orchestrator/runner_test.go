@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+func Example_measureIterations() {
+	file, err := ioutil.TempFile("", "iterations")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(iterationStats)
+	file.Close()
+
+	scns, err := parse([]byte(repeatTestYaml))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, m := range scns[0].Measure {
+		fmt.Println(measure(m, defaultConfig(), file.Name(), nil))
+	}
+
+	// Output:
+	// PASS cycle.*.kill cycle.*.start count ping.send in (1,3): mean 2, min 1, max 3
+	//   PASS cycle.1.kill cycle.1.start count ping.send in (1,3): 1
+	//   PASS cycle.2.kill cycle.2.start count ping.send in (1,3): 3
+	// FAIL cycle.*.kill cycle.*.start count ping.send is 1: 1 of 2 iterations failed
+	//   PASS cycle.1.kill cycle.1.start count ping.send is 1: 1
+	//   FAIL cycle.2.kill cycle.2.start count ping.send is 1: FAILED assertion: expected 1 got 3
+}
+
+var repeatTestYaml = `
+scenarios:
+- name: repeat
+  size: 2
+  script:
+  - t0: cluster-start
+  - cycle:
+      repeat: <N>
+      script:
+      - kill: kill 1
+      - start: start 1
+  measure:
+  - cycle.*.kill cycle.*.start count ping.send in (1, 3)
+  - cycle.*.kill cycle.*.start count ping.send is 1
+  runs:
+  - [<N>]
+  - [2]
+`
+
+var iterationStats = `label:t0|cmd: cluster-start
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+label:cycle.1.kill|cmd: kill 1
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+label:cycle.1.start|cmd: start 1
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+label:cycle.2.kill|cmd: kill 1
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+2016-06-15T16:11:08.198212784Z|ringpop.172_18_24_220_3000.ping.send:1|c
+label:cycle.2.start|cmd: start 1
+`
+
+func ExampleRunner_measureAll() {
+	file, err := ioutil.TempFile("", "severity")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(iterationStats)
+	file.Close()
+
+	scns, err := parse([]byte(severityTestYaml))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r := &Runner{}
+	for _, scn := range scns {
+		for _, result := range r.measureAll(scn, file.Name(), "") {
+			fmt.Println(result, result.Failed(), result.Warned())
+		}
+	}
+
+	// Output:
+	// WARN t0 cycle.1.kill count ping.send is 2: FAILED assertion: expected 2 got 1 false true
+	// FAIL t0 cycle.1.kill count ping.send is 3: FAILED assertion: expected 3 got 1 true false
+	// SKIP t0 cycle.1.kill count ping.send is 1 false false
+	// FAIL t0 cycle.1.kill count ping.send is 3: FAILED assertion: expected 3 got 1 true false
+	// PASS t0 cycle.1.kill count ping.send is 1: 1 false false
+}
+
+// The first run that updates the baseline has no baseline to compare with,
+// the values are measured without evaluating the assertions.
+func ExampleRunner_measureAll_updateBaseline() {
+	file, err := ioutil.TempFile("", "baseline")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(iterationStats)
+	file.Close()
+
+	scns, err := parse([]byte(baselineTestYaml))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scn := scns[0]
+	r := &Runner{Baseline: NewBaseline(), UpdateBaseline: true}
+	results := r.measureAll(scn, file.Name(), "")
+	for _, result := range results {
+		fmt.Println(result, result.Failed())
+	}
+
+	r.Baseline.Update(scn, results)
+	fmt.Println(r.Baseline.Value(scn, scn.Measure[0]), r.Baseline.Value(scn, scn.Measure[1]))
+
+	// Output:
+	// NEW  t0 cycle.2.kill count ping.send within 10% of baseline: 3 (no baseline yet) false
+	// NEW  cycle.2.kill cycle.2.start count ping.send <= baseline - 1: 3 (no baseline yet) false
+	// PASS cycle.*.kill cycle.*.start count ping.send in (1,3): mean 2, min 1, max 3
+	//   PASS cycle.1.kill cycle.1.start count ping.send in (1,3): 1
+	//   PASS cycle.2.kill cycle.2.start count ping.send in (1,3): 3 false
+	// 3 3
+}
+
+var severityTestYaml = `
+config:
+  abort-on-failure: true
+scenarios:
+- name: abort
+  size: <N>
+  script:
+  - t0: cluster-start
+  - cycle.1.kill: kill 1
+  measure:
+  - expect t0 cycle.1.kill count ping.send is 2
+  - require t0 cycle.1.kill count ping.send is 3
+  - t0 cycle.1.kill count ping.send is 1
+  runs:
+  - [<N>]
+  - [2]
+- name: continue
+  size: <N>
+  config:
+    abort-on-failure: false
+  script:
+  - t0: cluster-start
+  - cycle.1.kill: kill 1
+  measure:
+  - t0 cycle.1.kill count ping.send is 3
+  - t0 cycle.1.kill count ping.send is 1
+  runs:
+  - [<N>]
+  - [2]
+`
+
+// fakeExecutor runs no cluster, it prints the commands it executes.
+type fakeExecutor struct{}
+
+func (fakeExecutor) Handlers() CommandHandlers {
+	handlers := make(CommandHandlers)
+	for _, cmd := range []string{"cluster-start", "kill", "start"} {
+		cmd := cmd
+		handlers[cmd] = func(args []string) error {
+			fmt.Println(strings.Join(append([]string{"run", cmd}, args...), " "))
+			return nil
+		}
+	}
+	return handlers
+}
+
+func (fakeExecutor) Hosts() []string { return nil }
+func (fakeExecutor) Close() error    { return nil }
+
+// A failed required measurement stops the script before the command at its
+// end label, the measurements that need the rest of the script are skipped.
+func ExampleRunner_Run_abortOnFailure() {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// find a free udp port for the stats
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	conn.Close()
+
+	scns, err := parse([]byte(abortTestYaml))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r := &Runner{
+		NewExecutor: func(config *Config, size int) (Executor, error) {
+			return fakeExecutor{}, nil
+		},
+	}
+	for i, scn := range scns {
+		scn.Config.StatsDir = dir
+		scn.Config.StatsPort = conn.LocalAddr().(*net.UDPAddr).Port
+		results, err := r.Run(scn, i)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, result := range results {
+			fmt.Println(result)
+		}
+	}
+
+	// Output:
+	// run cluster-start
+	// FAIL t0 t1 count ping.send is 1: FAILED assertion: expected 1 got 0
+	// SKIP t1 t2 count ping.send is 0
+	// run cluster-start
+	// run kill 1
+	// run start 1
+	// FAIL t0 t1 count ping.send is 1: FAILED assertion: expected 1 got 0
+	// PASS t1 t2 count ping.send is 0: 0
+}
+
+var abortTestYaml = `
+config:
+  abort-on-failure: true
+scenarios:
+- name: abort
+  size: <N>
+  script:
+  - t0: cluster-start
+  - t1: kill 1
+  - t2: start 1
+  measure:
+  - t0 t1 count ping.send is 1
+  - t1 t2 count ping.send is 0
+  runs:
+  - [<N>]
+  - [2]
+- name: continue
+  size: <N>
+  config:
+    abort-on-failure: false
+  script:
+  - t0: cluster-start
+  - t1: kill 1
+  - t2: start 1
+  measure:
+  - t0 t1 count ping.send is 1
+  - t1 t2 count ping.send is 0
+  runs:
+  - [<N>]
+  - [2]
+`
+
+func ExampleRunner_Run_executorError() {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// find a free udp port for the stats
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	conn.Close()
+
+	config := defaultConfig()
+	config.StatsDir = dir
+	config.StatsPort = conn.LocalAddr().(*net.UDPAddr).Port
+	r := &Runner{
+		NewExecutor: func(config *Config, size int) (Executor, error) {
+			return nil, errors.New("no binary")
+		},
+	}
+
+	// the stats port is released when the executor can't be created
+	scn := &Scenario{Name: "fail", Size: 1, Config: config}
+	for i := 0; i < 2; i++ {
+		_, err := r.Run(scn, i)
+		fmt.Println(strings.TrimSpace(err.Error()))
+	}
+
+	// Output:
+	// run scenario fail
+	// : create executor: no binary
+	// run scenario fail
+	// : create executor: no binary
+}
@@ -31,6 +31,17 @@ type Scenario struct {
 	Size int
 	Desc string
 
+	// The values of the variables of the run of the scenario keyed by the
+	// names of the variables without angle brackets.
+	Vars map[string]string
+
 	Script  []*Command
 	Measure []*Measurement
+
+	// The criteria that determine when wait-for-stable considers the
+	// cluster stable. When empty, the default criteria are used.
+	Stable []StabilityCriterion
+
+	// The settings of the orchestrator for this scenario.
+	Config *Config
 }
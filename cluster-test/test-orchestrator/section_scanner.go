@@ -77,7 +77,7 @@ func NewSectionScanner(scanner Scanner, start, end string) (*SectionScanner, err
 
 	// find section start
 	for s.Scanner.Scan() {
-		if strings.HasPrefix(s.Text(), "label:"+s.Start) {
+		if isLabel(s.Text(), s.Start) {
 			return s, nil
 		}
 	}
@@ -96,9 +96,15 @@ func (s *SectionScanner) Scan() bool {
 		return true
 	}
 
-	if strings.HasPrefix(s.Scanner.Text(), "label:"+s.End) {
+	if isLabel(s.Scanner.Text(), s.End) {
 		return false
 	}
 
 	return true
 }
+
+// isLabel returns whether the line is the line of the label. The label must
+// match exactly so that label t1 doesn't match the line of label t1.kill.
+func isLabel(line, label string) bool {
+	return strings.HasPrefix(line, "label:"+label+"|")
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the criteria the StatIngester uses to decide whether the
+// cluster is stable. A scenario can combine multiple criteria, the cluster is
+// stable when all of them hold.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A StabilityCriterion decides from the stats ingested by a StatIngester
+// whether the cluster is stable.
+type StabilityCriterion interface {
+	// Check returns nil when the given hosts are stable according to the
+	// criterion and otherwise an error that describes why they are not. It
+	// is called while the StatIngester is locked.
+	Check(si *StatIngester, hosts []string) error
+
+	String() string
+}
+
+// A timedCriterion is a StabilityCriterion whose outcome can change by the
+// passing of time alone, without new stats coming in.
+type timedCriterion interface {
+	// recheckAfter returns after how much time the criterion should be
+	// checked again. It is called while the StatIngester is locked.
+	recheckAfter(si *StatIngester) time.Duration
+}
+
+// defaultStabilityCriteria are used when a scenario declares no criteria.
+var defaultStabilityCriteria = []StabilityCriterion{disseminateCriterion{1}}
+
+// disseminateCriterion holds when all hosts reported that they have no
+// changes to disseminate for the last Periods protocol periods.
+type disseminateCriterion struct {
+	Periods int
+}
+
+func (c disseminateCriterion) String() string {
+	if c.Periods == 1 {
+		return "disseminate"
+	}
+	return fmt.Sprintf("disseminate %d", c.Periods)
+}
+
+func (c disseminateCriterion) Check(si *StatIngester, hosts []string) error {
+	var pending, silent []string
+	for _, h := range hosts {
+		hs := statHostport(h)
+		if _, ok := si.emptyNodes[hs]; !ok {
+			silent = append(silent, h)
+		} else if si.emptyStreaks[hs] < c.Periods {
+			pending = append(pending, h)
+		}
+	}
+
+	var msgs []string
+	if len(pending) > 0 {
+		msgs = append(msgs, fmt.Sprintf("hosts still disseminating changes: %v", pending))
+	}
+	if len(silent) > 0 {
+		msgs = append(msgs, fmt.Sprintf("hosts that never reported %s: %v", changesDisseminatePath, silent))
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+// checksumsCriterion holds when all hosts last reported the same membership
+// checksum.
+type checksumsCriterion struct{}
+
+func (checksumsCriterion) String() string { return "checksums" }
+
+func (checksumsCriterion) Check(si *StatIngester, hosts []string) error {
+	csums := make(map[float64][]string)
+	var silent []string
+	for _, h := range hosts {
+		csum, ok := si.checksums[statHostport(h)]
+		if !ok {
+			silent = append(silent, h)
+			continue
+		}
+		csums[csum] = append(csums[csum], h)
+	}
+
+	if len(silent) > 0 {
+		msg := fmt.Sprintf("hosts that never reported a membership checksum %v", silent)
+		return errors.New(msg)
+	}
+	if len(csums) > 1 {
+		msg := fmt.Sprintf("hosts disagree on the membership checksum %v", csums)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// sizeCriterion holds when all hosts last reported a membership size that is
+// equal to the number of hosts that should be alive.
+type sizeCriterion struct{}
+
+func (sizeCriterion) String() string { return "size" }
+
+func (sizeCriterion) Check(si *StatIngester, hosts []string) error {
+	expected := float64(len(hosts))
+	var wrong []string
+	for _, h := range hosts {
+		if members, ok := si.members[statHostport(h)]; !ok || members != expected {
+			wrong = append(wrong, h)
+		}
+	}
+
+	if len(wrong) > 0 {
+		msg := fmt.Sprintf("hosts that did not report %v members %v", expected, wrong)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// quietCriterion holds when no membership changes were ingested during the
+// last Period.
+type quietCriterion struct {
+	Period time.Duration
+}
+
+func (c quietCriterion) String() string { return fmt.Sprintf("quiet %v", c.Period) }
+
+func (c quietCriterion) Check(si *StatIngester, hosts []string) error {
+	if wait := c.recheckAfter(si); wait > 0 {
+		msg := fmt.Sprintf("membership changed %v ago, not quiet for %v", c.Period-wait, c.Period)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (c quietCriterion) recheckAfter(si *StatIngester) time.Duration {
+	if si.lastChange.IsZero() {
+		return 0
+	}
+	return c.Period - si.now().Sub(si.lastChange)
+}
+
+// parseStabilityCriterion parses a criterion from the stable section of a
+// scenario. It can be one of:
+//
+// - `disseminate [<PERIODS>]`
+// - `checksums`
+// - `size`
+// - `quiet <DURATION>`
+func parseStabilityCriterion(str string) (StabilityCriterion, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return nil, errors.New("empty stability criterion")
+	}
+
+	switch {
+	case fields[0] == "disseminate" && len(fields) == 1:
+		return disseminateCriterion{1}, nil
+	case fields[0] == "disseminate" && len(fields) == 2:
+		periods, err := strconv.Atoi(fields[1])
+		if err != nil || periods < 1 {
+			msg := fmt.Sprintf("periods %s is not a positive integer", fields[1])
+			return nil, errors.New(msg)
+		}
+		return disseminateCriterion{periods}, nil
+	case fields[0] == "checksums" && len(fields) == 1:
+		return checksumsCriterion{}, nil
+	case fields[0] == "size" && len(fields) == 1:
+		return sizeCriterion{}, nil
+	case fields[0] == "quiet" && len(fields) == 2:
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "quiet period")
+		}
+		if d <= 0 {
+			msg := fmt.Sprintf("quiet period %s is not positive", fields[1])
+			return nil, errors.New(msg)
+		}
+		return quietCriterion{d}, nil
+	}
+
+	msg := fmt.Sprintf("unknown stability criterion '%s'", str)
+	return nil, errors.New(msg)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func ExampleStabilityCriterion() {
+	now := time.Date(2016, 6, 15, 16, 11, 8, 0, time.UTC)
+	criteria := []StabilityCriterion{
+		disseminateCriterion{1},
+		checksumsCriterion{},
+		sizeCriterion{},
+		quietCriterion{time.Second},
+	}
+	si := NewStatIngester(nopWriter{}, criteria...)
+	si.now = func() time.Time { return now }
+	si.IngestStats(bufio.NewScanner(strings.NewReader(stabilityStats)))
+
+	hosts := []string{"172.18.24.220:3000", "172.18.24.220:3001"}
+	now = now.Add(300 * time.Millisecond)
+	for _, c := range criteria {
+		fmt.Printf("%s: %v\n", c, c.Check(si, hosts))
+	}
+	fmt.Println(si.IsClusterStable(hosts))
+
+	now = now.Add(time.Second)
+	fmt.Println(criteria[3].Check(si, hosts))
+
+	// Output:
+	// disseminate: <nil>
+	// checksums: hosts disagree on the membership checksum map[1234:[172.18.24.220:3000] 4321:[172.18.24.220:3001]]
+	// size: hosts that did not report 2 members [172.18.24.220:3001]
+	// quiet 1s: membership changed 300ms ago, not quiet for 1s
+	// false
+	// <nil>
+}
+
+func Example_parseStabilityCriterion() {
+	for _, str := range []string{"disseminate", "disseminate 3", "disseminate 0", "checksums", "size", "quiet 2s", "quiet 0s", "quiet -1s", "quiet", "stable"} {
+		fmt.Println(parseStabilityCriterion(str))
+	}
+
+	// Output:
+	// disseminate <nil>
+	// disseminate 3 <nil>
+	// <nil> periods 0 is not a positive integer
+	// checksums <nil>
+	// size <nil>
+	// quiet 2s <nil>
+	// <nil> quiet period 0s is not positive
+	// <nil> quiet period -1s is not positive
+	// <nil> unknown stability criterion 'quiet'
+	// <nil> unknown stability criterion 'stable'
+}
+
+var stabilityStats = `
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.changes.disseminate:0|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3001.changes.disseminate:0|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.checksum:1234|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.ring.checksum:9999|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3001.checksum:4321|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.num-members:2|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3001.num-members:3|g
+2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3001.membership-set.alive:1|c
+`
@@ -0,0 +1,197 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the parser of the stats lines that the StatIngester
+// writes, e.g. "2016-06-15T16:11:08.19Z|ringpop.172_18_24_220_3000.ping:0.5|ms",
+// into typed Stats.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StatType is the statsd type of a Stat.
+type StatType string
+
+const (
+	StatTypeCounter StatType = "c"
+	StatTypeGauge   StatType = "g"
+	StatTypeTimer   StatType = "ms"
+	StatTypeSet     StatType = "s"
+)
+
+// A Stat is a single statsd stat with the time it was received.
+type Stat struct {
+	// The time the stat was received by the orchestrator.
+	Time time.Time
+
+	// The elements of the stat path before the hostport, e.g. "ringpop".
+	Prefix string
+
+	// The hostport of the node that emitted the stat in the form used in
+	// stat paths, e.g. "172_18_24_220_3000". Empty for stats that aren't
+	// emitted by a node, e.g. orchestrator.stability.flap.
+	Hostport string
+
+	// The path of the metric that follows the hostport, e.g. "ping.send", or
+	// the entire stat path when the stat has no hostport.
+	Path string
+
+	Value float64
+	Type  StatType
+
+	// The statsd sample rate of the stat, 1 when the stat is not sampled.
+	SampleRate float64
+}
+
+// statHostportRegex matches the element of a stat path that is the hostport
+// of a node, e.g. "172_18_24_220_3000" or "localhost_3000".
+var statHostportRegex = regexp.MustCompile("^[A-Za-z0-9-]+(_[A-Za-z0-9-]+)*_[0-9]{1,5}$")
+
+// ParseStat parses a line of the form "<timestamp>|<path>:<value>|<type>"
+// with an optional "|@<sample rate>".
+func ParseStat(line string) (*Stat, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 3 || len(fields) > 4 {
+		return nil, malformedStat(line, "expected <timestamp>|<path>:<value>|<type>")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return nil, malformedStat(line, "invalid timestamp")
+	}
+
+	ix := strings.LastIndex(fields[1], ":")
+	if ix <= 0 {
+		return nil, malformedStat(line, "expected <path>:<value>")
+	}
+	value, err := strconv.ParseFloat(fields[1][ix+1:], 64)
+	if err != nil {
+		return nil, malformedStat(line, "value is not a number")
+	}
+
+	typ := StatType(fields[2])
+	switch typ {
+	case StatTypeCounter, StatTypeGauge, StatTypeTimer, StatTypeSet:
+	default:
+		return nil, malformedStat(line, "unknown type "+fields[2])
+	}
+
+	rate := 1.0
+	if len(fields) == 4 {
+		if !strings.HasPrefix(fields[3], "@") {
+			return nil, malformedStat(line, "expected @<sample rate>")
+		}
+		rate, err = strconv.ParseFloat(fields[3][1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, malformedStat(line, "sample rate is not in (0, 1]")
+		}
+	}
+
+	stat := &Stat{
+		Time:       t,
+		Path:       fields[1][:ix],
+		Value:      value,
+		Type:       typ,
+		SampleRate: rate,
+	}
+
+	// split the path at the hostport
+	elements := strings.Split(stat.Path, ".")
+	for i, e := range elements {
+		if i > 0 && i < len(elements)-1 && statHostportRegex.MatchString(e) {
+			stat.Prefix = strings.Join(elements[:i], ".")
+			stat.Hostport = e
+			stat.Path = strings.Join(elements[i+1:], ".")
+			break
+		}
+	}
+
+	return stat, nil
+}
+
+// malformedStat returns the error for a line that is not a valid stat.
+func malformedStat(line, reason string) error {
+	msg := fmt.Sprintf("malformed stat \"%s\": %s", line, reason)
+	return errors.New(msg)
+}
+
+// FullPath returns the entire stat path including the prefix and hostport.
+func (s *Stat) FullPath() string {
+	if s.Hostport == "" {
+		return s.Path
+	}
+	return s.Prefix + "." + s.Hostport + "." + s.Path
+}
+
+// A StatScanner wraps a Scanner and parses its lines into Stats. Empty lines
+// and labels are skipped. Scanning stops at the first malformed line, the
+// error is then returned by Err.
+type StatScanner struct {
+	s    Scanner
+	stat *Stat
+	err  error
+}
+
+// NewStatScanner returns a StatScanner that parses the lines of s.
+func NewStatScanner(s Scanner) *StatScanner {
+	return &StatScanner{s: s}
+}
+
+// Scan parses the next stat and returns whether there is one.
+func (ss *StatScanner) Scan() bool {
+	if ss.err != nil {
+		return false
+	}
+	for ss.s.Scan() {
+		line := ss.s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "label:") {
+			continue
+		}
+		ss.stat, ss.err = ParseStat(line)
+		return ss.err == nil
+	}
+	return false
+}
+
+// Stat returns the scanned Stat.
+func (ss *StatScanner) Stat() *Stat {
+	return ss.stat
+}
+
+// Text returns the line of the scanned Stat.
+func (ss *StatScanner) Text() string {
+	return ss.s.Text()
+}
+
+// Err returns the first malformed line or error of the wrapped Scanner.
+func (ss *StatScanner) Err() error {
+	if ss.err != nil {
+		return ss.err
+	}
+	return ss.s.Err()
+}
@@ -19,12 +19,14 @@
 // THE SOFTWARE.
 
 // This file contains the static ringpop stats analysis for: convergence time;
-// number of converged checksums; and counting of individual stats.
+// number of converged checksums; counting of individual stats and their
+// lines; and the number of nodes that went silent.
 
 package main
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 	"time"
@@ -32,129 +34,282 @@ import (
 	"github.com/pkg/errors"
 )
 
+// The paths of the metrics that the analyses and the StatIngester use,
+// relative to the hostport of the node.
 const (
-	membershipChecksumPath = ".checksum:"
-	changesDisseminatePath = "changes.disseminate:"
+	membershipChecksumPath = "checksum"
+	changesDisseminatePath = "changes.disseminate"
 	membershipSetPath      = "membership-set"
-	hostportRegex          = "[0-9]{1,3}_[0-9]{1,3}_[0-9]{1,3}_[0-9]{1,3}_[0-9]{1,6}"
+	numMembersPath         = "num-members"
+	stabilityFlapPath      = "orchestrator.stability.flap"
 )
 
-// CountAnalysis counts the number of occurences of stat in the scanner.
-func CountAnalysis(s Scanner, stat string) (int, error) {
-	stat += ":"
+// CountAnalysis sums the values of the counters in the scanner that match
+// stat, see matchStat. The value of a sampled counter is scaled by its sample
+// rate, e.g. a 1 sampled at @0.1 counts as 10. Stats of nodes are only counted
+// when they have the prefix, see hasPrefix.
+func CountAnalysis(s Scanner, prefix, stat string) (float64, error) {
+	if err := checkStatPattern(stat); err != nil {
+		return 0, errors.Wrap(err, "count analysis\n")
+	}
+
+	ss := NewStatScanner(s)
+	var count float64
+	for ss.Scan() {
+		st := ss.Stat()
+		if hasPrefix(st, prefix) && st.Type == StatTypeCounter && matchStat(stat, st) {
+			count += st.Value / st.SampleRate
+		}
+	}
+	if ss.Err() != nil {
+		return 0, errors.Wrap(ss.Err(), "count analysis\n")
+	}
+
+	return count, nil
+}
+
+// CountPerNodeAnalysis is CountAnalysis per node. Nodes that emitted stats
+// but no matching counters count zero, counters without a hostport are left
+// out.
+func CountPerNodeAnalysis(s Scanner, prefix, stat string) (map[string]float64, error) {
+	if err := checkStatPattern(stat); err != nil {
+		return nil, errors.Wrap(err, "count analysis\n")
+	}
+
+	counts := make(map[string]float64)
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		st := ss.Stat()
+		if st.Hostport == "" || st.Prefix != prefix {
+			continue
+		}
+		count := counts[st.Hostport]
+		if st.Type == StatTypeCounter && matchStat(stat, st) {
+			count += st.Value / st.SampleRate
+		}
+		counts[st.Hostport] = count
+	}
+	if ss.Err() != nil {
+		return nil, errors.Wrap(ss.Err(), "count analysis\n")
+	}
+
+	return counts, nil
+}
+
+// LinesAnalysis counts the lines of the stats in the scanner of which the
+// stat path ends with stat, a regular expression. Unlike CountAnalysis it
+// counts stats of every type and ignores their values.
+func LinesAnalysis(s Scanner, prefix, stat string) (int, error) {
+	r, err := regexp.Compile(stat + "$")
+	if err != nil {
+		return 0, errors.Wrap(err, "lines analysis\n")
+	}
+
+	ss := NewStatScanner(s)
 	count := 0
-	for s.Scan() {
-		// TODO fetch actual count from stat line (don't just count number of lines)
-		if ok, err := regexp.MatchString(stat, s.Text()); ok && err == nil {
+	for ss.Scan() {
+		if hasPrefix(ss.Stat(), prefix) && r.MatchString(ss.Stat().FullPath()) {
 			count++
 		}
 	}
-	if s.Err() != nil {
-		return 0, errors.Wrap(s.Err(), "count analysis\n")
+	if ss.Err() != nil {
+		return 0, errors.Wrap(ss.Err(), "lines analysis\n")
 	}
 
 	return count, nil
 }
 
+// LinesPerNodeAnalysis is LinesAnalysis per node. Nodes that emitted stats
+// but no matching lines count zero.
+func LinesPerNodeAnalysis(s Scanner, prefix, stat string) (map[string]float64, error) {
+	r, err := regexp.Compile(stat + "$")
+	if err != nil {
+		return nil, errors.Wrap(err, "lines analysis\n")
+	}
+
+	lines := make(map[string]float64)
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		st := ss.Stat()
+		if st.Hostport == "" || st.Prefix != prefix {
+			continue
+		}
+		count := lines[st.Hostport]
+		if r.MatchString(st.FullPath()) {
+			count++
+		}
+		lines[st.Hostport] = count
+	}
+	if ss.Err() != nil {
+		return nil, errors.Wrap(ss.Err(), "lines analysis\n")
+	}
+
+	return lines, nil
+}
+
+// hasPrefix indicates whether the stat is emitted by a node with the given
+// prefix or is not emitted by a node at all, e.g. the stats of the
+// orchestrator.
+func hasPrefix(stat *Stat, prefix string) bool {
+	return stat.Hostport == "" || stat.Prefix == prefix
+}
+
+// matchStat returns whether the metric path or the full path of the stat
+// matches the pattern. The pattern matches exactly, e.g. "ping.send" doesn't
+// match "ping-req.send", unless it is a glob that contains "*", "?" or "[",
+// e.g. "membership-set.*".
+func matchStat(pattern string, stat *Stat) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern == stat.Path || pattern == stat.FullPath()
+	}
+	ok, _ := path.Match(pattern, stat.Path)
+	if !ok {
+		ok, _ = path.Match(pattern, stat.FullPath())
+	}
+	return ok
+}
+
+// checkStatPattern returns an error when the pattern is a malformed glob.
+func checkStatPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.Wrapf(err, "stat pattern %s", pattern)
+	}
+	return nil
+}
+
 // ChecksumsAnalysis counts the number of unique checksums among nodes after
 // scanning all the stats in the scanner.
-func ChecksumsAnalysis(s Scanner) (int, error) {
-	m := make(map[string]string)
-	for s.Scan() {
-		line := s.Text()
-		ix := strings.Index(line, membershipChecksumPath)
+func ChecksumsAnalysis(s Scanner, prefix string) (int, error) {
+	m, err := ChecksumsPerNodeAnalysis(s, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return uniq(m), nil
+}
+
+// ChecksumsPerNodeAnalysis returns the last membership checksum of every node
+// with the prefix after scanning all the stats in the scanner.
+func ChecksumsPerNodeAnalysis(s Scanner, prefix string) (map[string]float64, error) {
+	m := make(map[string]float64)
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		stat := ss.Stat()
 
 		// filter out everything that is not a membership checksum
-		if ix == -1 || strings.Contains(line, "ring.checksum") {
+		if stat.Path != membershipChecksumPath || !hasPrefix(stat, prefix) {
 			continue
 		}
 
-		csum := line[ix+len(membershipChecksumPath):]
-		if csum[len(csum)-2:] != "|g" {
-			msg := fmt.Sprintf("membership.checksum is not a gauge. csum=%s", csum)
-			return 0, errors.New(msg)
+		if stat.Type != StatTypeGauge {
+			msg := fmt.Sprintf("membership.checksum is not a gauge. stat=%s", ss.Text())
+			return nil, errors.New(msg)
 		}
-		csum = csum[:len(csum)-2]
-
-		r := regexp.MustCompile(hostportRegex)
-		host := r.FindString(line)
-		if host == "" {
-			msg := fmt.Sprintf("membership.checksum stat \"%s\" does not contain host", line)
-			return 0, errors.New(msg)
+		if stat.Hostport == "" {
+			msg := fmt.Sprintf("membership.checksum stat \"%s\" does not contain host", ss.Text())
+			return nil, errors.New(msg)
 		}
-		m[host] = csum
+		m[stat.Hostport] = stat.Value
 	}
-	if s.Err() != nil {
-		return 0, errors.Wrap(s.Err(), "checksums analysis\n")
+	if ss.Err() != nil {
+		return nil, errors.Wrap(ss.Err(), "checksums analysis\n")
 	}
 
-	return uniq(m), nil
+	return m, nil
 }
 
 // uniq returns the number of unique values in a map.
-func uniq(m map[string]string) int {
-	u := make(map[string]struct{})
+func uniq(m map[string]float64) int {
+	u := make(map[float64]struct{})
 	for _, csum := range m {
 		u[csum] = struct{}{}
 	}
 	return len(u)
 }
 
+// isMembershipChange returns whether the stat records a membership change.
+func isMembershipChange(stat *Stat) bool {
+	return strings.HasPrefix(stat.Path, membershipSetPath+".")
+}
+
 // ConvergenceTimeAnalysis measures the time it takes from the first changes is
-// applied until the last.
-func ConvergenceTimeAnalysis(s Scanner) (time.Duration, error) {
-	var firstChange string
-	var lastChange string
-	for s.Scan() {
-		if strings.Contains(s.Text(), membershipSetPath) {
-			firstChange = s.Text()
-			lastChange = s.Text()
-			break
+// applied until the last by the nodes with the prefix.
+func ConvergenceTimeAnalysis(s Scanner, prefix string) (time.Duration, error) {
+	var firstChange, lastChange *Stat
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		if isMembershipChange(ss.Stat()) && hasPrefix(ss.Stat(), prefix) {
+			if firstChange == nil {
+				firstChange = ss.Stat()
+			}
+			lastChange = ss.Stat()
 		}
 	}
-	if firstChange == "" {
-		return 0, errors.New("first membership change not found in convergence time analysis")
-	}
-
-	for s.Scan() {
-		if strings.Contains(s.Text(), membershipSetPath) {
-			lastChange = s.Text()
-		}
+	if ss.Err() != nil {
+		return 0, errors.Wrap(ss.Err(), "convergence time analysis\n")
 	}
-	if s.Err() != nil {
-		return 0, errors.Wrap(s.Err(), "convergence time analysis\n")
+	if firstChange == nil {
+		return 0, errors.New("first membership change not found in convergence time analysis")
 	}
 
-	d, err := timeDiff(firstChange, lastChange)
-	if err != nil {
-		return 0, errors.Wrap(err, "convergence time analaysis\n")
-	}
+	d := lastChange.Time.Sub(firstChange.Time)
 
 	// force millisecond precission
 	return d / time.Millisecond * time.Millisecond, nil
 }
 
-// timeDiff returns the duration between two stat lines.
-func timeDiff(stat1, stat2 string) (time.Duration, error) {
-	i1 := strings.Index(stat1, "|")
-	if i1 == -1 {
-		msg := fmt.Sprintf("stat1 \"%s\" doesn't contain a timestamp", stat1)
-		return 0, errors.New(msg)
+// ConvergenceTimePerNodeAnalysis measures per node the time from the first
+// membership change it applied until its last. Nodes that applied no changes
+// are left out.
+func ConvergenceTimePerNodeAnalysis(s Scanner, prefix string) (map[string]time.Duration, error) {
+	first := make(map[string]time.Time)
+	convtimes := make(map[string]time.Duration)
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		stat := ss.Stat()
+		if stat.Hostport == "" || stat.Prefix != prefix || !isMembershipChange(stat) {
+			continue
+		}
+		if _, ok := first[stat.Hostport]; !ok {
+			first[stat.Hostport] = stat.Time
+		}
+
+		// force millisecond precission
+		d := stat.Time.Sub(first[stat.Hostport])
+		convtimes[stat.Hostport] = d / time.Millisecond * time.Millisecond
 	}
-	i2 := strings.Index(stat2, "|")
-	if i2 == -1 {
-		msg := fmt.Sprintf("stat2 \"%s\" doesn't contain a timestamp", stat2)
-		return 0, errors.New(msg)
+	if ss.Err() != nil {
+		return nil, errors.Wrap(ss.Err(), "convergence time analysis\n")
 	}
 
-	t1, err := time.Parse(time.RFC3339Nano, stat1[:i1])
-	if err != nil {
-		return 0, errors.Wrap(err, "parse timestamp stat1\n")
+	return convtimes, nil
+}
+
+// SilentNodesAnalysis counts the nodes that stopped emitting stats. A node is
+// silent when the time between its last stat and the last stat in the scanner
+// is longer than the threshold. The hostport of a node follows the prefix in
+// the stat path.
+func SilentNodesAnalysis(s Scanner, prefix string, threshold time.Duration) (int, error) {
+	var end time.Time
+	last := make(map[string]time.Time)
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		stat := ss.Stat()
+		if stat.Hostport == "" || stat.Prefix != prefix {
+			continue
+		}
+		last[stat.Hostport] = stat.Time
+		end = stat.Time
 	}
-	t2, err := time.Parse(time.RFC3339Nano, stat2[:i2])
-	if err != nil {
-		return 0, errors.Wrap(err, "parse timestamp stat2\n")
+	if ss.Err() != nil {
+		return 0, errors.Wrap(ss.Err(), "silent nodes analysis\n")
+	}
+
+	silent := 0
+	for _, t := range last {
+		if end.Sub(t) > threshold {
+			silent++
+		}
 	}
 
-	return t2.Sub(t1), nil
+	return silent, nil
 }
@@ -26,6 +26,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -41,10 +42,27 @@ import (
 // cluster reaches a stable state. It also writes the stream into a file for
 // later analysis.
 type StatIngester struct {
+	// Hold is the duration the stability criteria must hold continuously
+	// before WaitForStable returns. Every time stability is lost during the
+	// hold a flap is recorded in the stats. Hold should be set before
+	// waiting.
+	Hold time.Duration
+
+	// SilentAfter is the duration after which a node that emitted no stats
+	// is considered silent. A cluster with silent nodes that should be alive
+	// is not stable. Zero disables the detection of silent nodes. It should
+	// be set before ingesting.
+	SilentAfter time.Duration
+
+	// StatsPrefix is the first element of the path of every stat, it is
+	// followed by the hostport of the node. It should be set before
+	// ingesting.
+	StatsPrefix string
+
 	// The where the stats are written to.
 	writer io.Writer
 
-	// Protects emptyNodes and wasUnstable
+	// Protects all fields below.
 	sync.Mutex
 
 	// The stat ingester listens for dissemination stats to determine if the
@@ -54,66 +72,311 @@ type StatIngester struct {
 	// have changes to disseminate.
 	emptyNodes map[string]bool
 
+	// The number of consecutive times every node reported that it has no
+	// changes to disseminate. Nodes report once every protocol period.
+	emptyStreaks map[string]int
+
+	// The last membership checksum and membership size reported by every
+	// node, and the time the last membership change was ingested. These are
+	// used by the StabilityCriteria other than disseminate.
+	checksums  map[string]float64
+	members    map[string]float64
+	lastChange time.Time
+
+	// The time every node last emitted a stat.
+	lastSeen map[string]time.Time
+
+	// The cluster is stable when all criteria hold.
+	criteria []StabilityCriterion
+
+	// now returns the current time, replaceable for testing.
+	now func() time.Time
+
 	// When waiting for the cluster to be stable, we first want to make sure
 	// that the cluster was unstable at some point. This makes sure that any
 	// failure condition we throw at the cluster has taken effect before we
-	// move onto the next failure condition.
-	wasUnstable bool
+	// move onto the next failure condition. unstableCount counts the stats
+	// that reported changes to disseminate, waitedCount is the unstableCount
+	// at the moment the last wait for stability completed. The cluster was
+	// unstable since then when unstableCount > waitedCount.
+	unstableCount int
+	waitedCount   int
+
+	// waiters is the number of goroutines that took their snapshot of
+	// waitedCount and are waiting for stability.
+	waiters int
+
+	// The hosts the waiters wait for and the time since which they are
+	// stable, zero when they are not. The hold is tracked here instead of by
+	// every waiter so that a flap is recorded once, see updateHold.
+	holdHosts   []string
+	stableSince time.Time
+
+	// changed is closed and replaced whenever the stability state changes,
+	// waking up all goroutines that wait for stability.
+	changed chan struct{}
 }
 
-// NewStatIngester creates a new StatIngester
-func NewStatIngester(w io.Writer) *StatIngester {
+// NewStatIngester creates a new StatIngester that determines stability with
+// the given criteria. When no criteria are given, the cluster is stable when
+// no node has changes to disseminate.
+func NewStatIngester(w io.Writer, criteria ...StabilityCriterion) *StatIngester {
+	if len(criteria) == 0 {
+		criteria = defaultStabilityCriteria
+	}
 	return &StatIngester{
-		emptyNodes: make(map[string]bool),
-		writer:     w,
+		emptyNodes:   make(map[string]bool),
+		emptyStreaks: make(map[string]int),
+		checksums:    make(map[string]float64),
+		members:      make(map[string]float64),
+		lastSeen:     make(map[string]time.Time),
+		criteria:     criteria,
+		now:          time.Now,
+		StatsPrefix:  "ringpop",
+		writer:       w,
+		changed:      make(chan struct{}),
 	}
 }
 
 // WaitForStable blocks and waits until the cluster has reached a stable state.
 // waits for the cluster to first become unstable if it isn't already, and then
-// blocks until the cluster has reached a stable state again.
-func (si *StatIngester) WaitForStable(hosts []string) {
-	// wait for cluster to become unstable
-	for !si.wasUnstable {
-		time.Sleep(200 * time.Millisecond)
+// blocks until the cluster has reached a stable state again. The error of
+// WaitForStableContext is returned.
+func (si *StatIngester) WaitForStable(hosts []string) error {
+	return si.WaitForStableContext(context.Background(), hosts)
+}
+
+// WaitForStableContext is like WaitForStable but gives up when the context is
+// done. The returned error describes which hosts kept the cluster from
+// becoming stable. Multiple goroutines can wait at the same time, all of them
+// return at the moment the cluster has been stable for the Hold duration. A
+// goroutine that starts waiting after another wait completed needs a new
+// instability, so concurrent waiters should all be waiting before the cluster
+// becomes stable.
+func (si *StatIngester) WaitForStableContext(ctx context.Context, hosts []string) error {
+	si.Lock()
+	since := si.waitedCount
+	if si.waiters == 0 {
+		si.holdHosts = hosts
+		si.stableSince = time.Time{}
+	}
+	si.waiters++
+	si.Unlock()
+	defer func() {
+		si.Lock()
+		si.waiters--
+		si.Unlock()
+	}()
+
+	for {
+		si.Lock()
+		now := si.now()
+		// timed criteria can change the stability without a new stat
+		si.updateHold(now)
+		wasUnstable := si.unstableCount > since
+		stable := wasUnstable && !si.stableSince.IsZero()
+		var holdLeft time.Duration
+		if stable {
+			holdLeft = si.Hold - now.Sub(si.stableSince)
+			if holdLeft <= 0 {
+				if si.waitedCount < si.unstableCount {
+					si.waitedCount = si.unstableCount
+				}
+				si.Unlock()
+				return nil
+			}
+		}
+		changed := si.changed
+		recheck := si.recheckAfter(hosts)
+		if holdLeft > 0 && (recheck == 0 || holdLeft < recheck) {
+			recheck = holdLeft
+		}
+		si.Unlock()
+
+		// some criteria need to be checked again after a while even when
+		// no new stats come in
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if recheck > 0 {
+			timer = time.NewTimer(recheck)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if !wasUnstable {
+				return errors.Wrap(ctx.Err(), "cluster never became unstable")
+			}
+			if stable {
+				msg := fmt.Sprintf("cluster did not stay stable for %v", si.Hold)
+				return errors.Wrap(ctx.Err(), msg)
+			}
+			return errors.Wrap(ctx.Err(), si.unstableReason(hosts))
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// updateHold keeps track of the time since which the hosts that are waited
+// for are stable, after the cluster was unstable. When the hosts lose
+// stability before they were stable for the Hold duration a flap is recorded.
+// The stability only changes once, so the flap is recorded once however many
+// goroutines are waiting. It is called while the StatIngester is locked.
+func (si *StatIngester) updateHold(now time.Time) {
+	if si.waiters == 0 {
+		return
+	}
+	stable := si.isClusterStable(si.holdHosts)
+	switch {
+	case stable && si.stableSince.IsZero() && si.unstableCount > si.waitedCount:
+		si.stableSince = now
+	case !stable && !si.stableSince.IsZero():
+		if now.Sub(si.stableSince) < si.Hold {
+			si.recordFlap(now)
+		}
+		si.stableSince = time.Time{}
+	}
+}
+
+// recordFlap writes a stat to the stats file that indicates that the cluster
+// lost stability before it was stable for the Hold duration. The flaps can be
+// measured with `count orchestrator.stability.flap`. It is called while the
+// StatIngester is locked.
+func (si *StatIngester) recordFlap(now time.Time) {
+	ts := now.UTC().Format(time.RFC3339Nano)
+	fmt.Fprintf(si.writer, "%s|%s:1|c\n", ts, stabilityFlapPath)
+}
+
+// recheckAfter returns the shortest time after which a timed criterion needs
+// to be checked again or one of the hosts becomes silent, or zero if there is
+// no such criterion or host.
+func (si *StatIngester) recheckAfter(hosts []string) time.Duration {
+	var min time.Duration
+	if si.SilentAfter > 0 {
+		now := si.now()
+		for _, h := range hosts {
+			seen, ok := si.lastSeen[statHostport(h)]
+			if d := seen.Add(si.SilentAfter).Sub(now); ok && d > 0 && (min == 0 || d < min) {
+				min = d
+			}
+		}
 	}
-	// wait for cluster to become stable
-	for !si.IsClusterStable(hosts) {
-		time.Sleep(200 * time.Millisecond)
+	for _, c := range si.criteria {
+		tc, ok := c.(timedCriterion)
+		if !ok {
+			continue
+		}
+		if d := tc.recheckAfter(si); d > 0 && (min == 0 || d < min) {
+			min = d
+		}
 	}
-	si.wasUnstable = false
+	return min
+}
+
+// unstableReason describes why the cluster is not stable by listing the
+// reasons of every criterion that does not hold.
+func (si *StatIngester) unstableReason(hosts []string) string {
+	si.Lock()
+	defer si.Unlock()
+
+	msgs := []string{"cluster never became stable"}
+	if silent := si.silentHosts(hosts); len(silent) > 0 {
+		msgs = append(msgs, fmt.Sprintf("hosts silent for more than %v %v", si.SilentAfter, silent))
+	}
+	for _, c := range si.criteria {
+		if err := c.Check(si, hosts); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	return strings.Join(msgs, ", ")
 }
 
 // IsClusterStable indicates, judging from the processed stats, whether the
 // cluster is in a stable state. The input are the hosts that should be
-// alive.
+// alive. The cluster is not stable when one of the hosts has gone silent.
 func (si *StatIngester) IsClusterStable(hosts []string) bool {
 	si.Lock()
 	defer si.Unlock()
 
-	for _, h := range hosts {
-		hs := strings.Replace(h, ".", "_", -1)
-		hs = strings.Replace(hs, ":", "_", -1)
-		if empty, ok := si.emptyNodes[hs]; !ok || !empty {
+	return si.isClusterStable(hosts)
+}
+
+// isClusterStable is IsClusterStable without locking.
+func (si *StatIngester) isClusterStable(hosts []string) bool {
+	if len(si.silentHosts(hosts)) > 0 {
+		return false
+	}
+	for _, c := range si.criteria {
+		if c.Check(si, hosts) != nil {
 			return false
 		}
 	}
 	return true
 }
 
+// SilentHosts returns the hosts that emitted stats in the past, but have not
+// done so for longer than SilentAfter.
+func (si *StatIngester) SilentHosts(hosts []string) []string {
+	si.Lock()
+	defer si.Unlock()
+
+	return si.silentHosts(hosts)
+}
+
+// silentHosts is SilentHosts without locking.
+func (si *StatIngester) silentHosts(hosts []string) []string {
+	if si.SilentAfter == 0 {
+		return nil
+	}
+
+	var silent []string
+	now := si.now()
+	for _, h := range hosts {
+		seen, ok := si.lastSeen[statHostport(h)]
+		if ok && now.Sub(seen) > si.SilentAfter {
+			silent = append(silent, h)
+		}
+	}
+	return silent
+}
+
+// statHostport converts a hostport to the form that is used in the stat
+// paths, e.g. "127.0.0.1:3000" -> "127_0_0_1_3000".
+func statHostport(hostport string) string {
+	hs := strings.Replace(hostport, ".", "_", -1)
+	return strings.Replace(hs, ":", "_", -1)
+}
+
 // IngestStats starts listening on the specified port for ringpop stats. The
 // stats are analyzed to determine cluster-stability and written to a file.
+// Malformed stats and stats that don't belong to a node of the cluster are
+// logged and left out of the file.
 func (si *StatIngester) IngestStats(s Scanner) error {
 	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		stat, err := ParseStat(s.Text())
+		if err != nil {
+			log.Printf("stat ingestion: %v", err)
+			continue
+		}
+
 		// handle stat for cluster stability analysis
-		err := si.handleStat(s.Text())
+		err = si.handleStat(stat)
 		if err != nil {
-			err = errors.Wrap(err, "stat ingestion")
-			log.Fatalf(err.Error())
+			log.Printf("stat ingestion: %v", err)
+			continue
 		}
 
 		// write stat to file
+		si.Lock()
 		_, err = fmt.Fprintln(si.writer, s.Text())
+		si.Unlock()
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -127,49 +390,60 @@ func (si *StatIngester) IngestStats(s Scanner) error {
 // that are recorded between two labels can be used to measure the effect of
 // the command associated with the first label.
 func (si *StatIngester) InsertLabel(label, cmd string) {
+	si.Lock()
+	defer si.Unlock()
 	fmt.Fprintf(si.writer, "label:%s|cmd: %s\n", label, cmd)
 }
 
 // handleStat handles a single stat to determine cluster-stability.
-func (si *StatIngester) handleStat(str string) error {
+func (si *StatIngester) handleStat(stat *Stat) error {
 	si.Lock()
 	defer si.Unlock()
 
-	// check if changes were disseminated
-	changes, ok := getBetween(str, "changes.disseminate:", "|")
-	if !ok {
+	// remember when every node was last heard of
+	hostport := stat.Hostport
+	if hostport != "" && stat.Prefix == si.StatsPrefix {
+		si.lastSeen[hostport] = si.now()
+	}
+
+	// filter out the stats that don't affect stability
+	isChanges := stat.Path == changesDisseminatePath
+	isChecksum := stat.Path == membershipChecksumPath
+	isMembers := stat.Path == numMembersPath
+	isMembershipSet := isMembershipChange(stat)
+	if !isChanges && !isChecksum && !isMembers && !isMembershipSet {
 		return nil
 	}
-	empty := changes == "0"
 
-	// lookup hostport
-	hostport, ok := getBetween(str, "ringpop.", ".")
-	if !ok {
-		msg := fmt.Sprintf("no hostport found in stat \"%s\"", str)
+	if hostport == "" || stat.Prefix != si.StatsPrefix {
+		msg := fmt.Sprintf("no hostport found in stat \"%s\"", stat.FullPath())
 		return errors.New(msg)
 	}
 
-	if !empty {
-		si.wasUnstable = true
+	switch {
+	case isChanges:
+		empty := stat.Value == 0
+		si.emptyNodes[hostport] = empty
+		if empty {
+			si.emptyStreaks[hostport]++
+		} else {
+			si.emptyStreaks[hostport] = 0
+			si.unstableCount++
+		}
+	case isChecksum:
+		si.checksums[hostport] = stat.Value
+	case isMembers:
+		si.members[hostport] = stat.Value
+	case isMembershipSet:
+		si.lastChange = si.now()
 	}
-	si.emptyNodes[hostport] = empty
 
-	return nil
-}
+	// the stat can end a hold, which is recorded as a flap
+	si.updateHold(si.now())
 
-// getBetween get a substring from the input buffer between before and after.
-// The function returns whether this was a success.
-func getBetween(str, before, after string) (string, bool) {
-	start := strings.Index(str, before)
-	if start == -1 {
-		return "", false
-	}
-	start += len(before)
-
-	end := strings.Index(str[start:], after)
-	if end == -1 {
-		return "", false
-	}
+	// wake up the waiters to check the stability criteria
+	close(si.changed)
+	si.changed = make(chan struct{})
 
-	return str[start : start+end], true
+	return nil
 }
@@ -2,8 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
+	"time"
 )
 
 type nopWriter struct{}
@@ -32,15 +37,203 @@ func ExampleStatIngester() {
 	// true
 }
 
-func ExampleWaitForStable() {
+// Stats that are malformed or have an unexpected prefix are left out of the
+// file.
+func ExampleStatIngester_IngestStats() {
+	var buf bytes.Buffer
+	si := NewStatIngester(&buf)
+	si.IngestStats(bufio.NewScanner(strings.NewReader(
+		"not a stat\n" +
+			"2016-06-15T16:11:08.198191045Z|other.172_18_24_220_3000.num-members:1|g\n" +
+			"2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.num-members:1|g\n",
+	)))
+	fmt.Print(buf.String())
+
+	// Output:
+	// 2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.num-members:1|g
+}
+
+func ExampleStatIngester_WaitForStable() {
 	si := NewStatIngester(nopWriter{})
 	scanner := bufio.NewScanner(strings.NewReader(stats2))
 	si.IngestStats(scanner)
-	si.WaitForStable(
+	fmt.Println(si.WaitForStable(
 		[]string{"172.18.24.220:3000", "172.18.24.220:3001"},
-	)
+	))
+
+	// Output:
+	// <nil>
+}
+
+func ExampleStatIngester_WaitForStableContext() {
+	si := NewStatIngester(nopWriter{})
+	scanner := bufio.NewScanner(strings.NewReader(stats2))
+	si.IngestStats(scanner)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	fmt.Println(si.WaitForStableContext(ctx,
+		[]string{"172.18.24.220:3000", "172.18.24.220:3002", "172.18.24.220:3003"},
+	))
+
+	// Output:
+	// cluster never became stable, hosts still disseminating changes: [172.18.24.220:3002], hosts that never reported changes.disseminate: [172.18.24.220:3003]: context deadline exceeded
+}
+
+// Multiple goroutines wait for the cluster to become stable while the stats
+// are ingested.
+func ExampleStatIngester_WaitForStable_concurrent() {
+	now := time.Date(2016, 6, 15, 16, 11, 8, 0, time.UTC)
+	r, w := io.Pipe()
+	si := NewStatIngester(nopWriter{})
+	si.SilentAfter = 0
+	var waiting <-chan struct{}
+	si.now, waiting = waiterClock(&now, 3)
+	go si.IngestStats(bufio.NewScanner(r))
+
+	hosts := []string{"172.18.24.220:3000", "172.18.24.220:3001"}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			if err := si.WaitForStable(hosts); err != nil {
+				fmt.Println(err)
+			}
+			wg.Done()
+		}()
+	}
+	// the waiters must have started waiting before the cluster becomes
+	// stable, a later waiter would wait for a new instability
+	for i := 0; i < 3; i++ {
+		<-waiting
+	}
+
+	fmt.Fprintln(w, "2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.changes.disseminate:1|g")
+	fmt.Fprintln(w, "2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3001.changes.disseminate:0|g")
+	fmt.Println(si.IsClusterStable(hosts))
+	fmt.Fprintln(w, "2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.changes.disseminate:0|g")
+	wg.Wait()
+	fmt.Println(si.IsClusterStable(hosts))
+	w.Close()
+
+	// Output:
+	// false
+	// true
+}
+
+// The cluster must stay stable for the Hold duration, when it becomes unstable
+// during the hold a flap is recorded. The clock only moves when the example
+// advances it, so the hold can't pass by accident.
+func ExampleStatIngester_Hold() {
+	fmt.Println(holdFlaps(1))
+
+	// Output:
+	// 1
+}
+
+// A flap is recorded once, however many goroutines wait for stability.
+func ExampleStatIngester_Hold_concurrent() {
+	fmt.Println(holdFlaps(2))
+
+	// Output:
+	// 1
+}
+
+// holdFlaps lets the cluster flap once while the given number of goroutines
+// wait for stability and returns the number of flaps that are recorded.
+func holdFlaps(waiters int) int {
+	now := time.Date(2016, 6, 15, 16, 11, 8, 0, time.UTC)
+	r, w := io.Pipe()
+	var buf bytes.Buffer
+	si := NewStatIngester(&buf)
+	si.Hold = time.Hour
+	si.SilentAfter = 0
+	var waiting <-chan struct{}
+	si.now, waiting = waiterClock(&now, waiters)
+	go si.IngestStats(bufio.NewScanner(r))
+
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			if err := si.WaitForStable([]string{"172.18.24.220:3000"}); err != nil {
+				fmt.Println(err)
+			}
+			wg.Done()
+		}()
+	}
+	for i := 0; i < waiters; i++ {
+		<-waiting
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	changes := func(n int) {
+		fmt.Fprintf(w, "2016-06-15T16:11:08.198191045Z|ringpop.172_18_24_220_3000.changes.disseminate:%d|g\n", n)
+	}
+
+	// become stable and unstable again before the hold has passed
+	changes(1)
+	changes(0)
+	changes(1)
+
+	// stay stable for the hold, the clock is advanced until the stable
+	// stat is ingested
+	for stable := false; !stable; {
+		changes(0)
+		si.Lock()
+		now = now.Add(si.Hold)
+		si.Unlock()
+		changes(0)
+		select {
+		case <-done:
+			stable = true
+		case <-time.After(time.Millisecond):
+		}
+	}
+	w.Close()
+
+	si.Lock()
+	defer si.Unlock()
+	return strings.Count(buf.String(), stabilityFlapPath)
+}
+
+// waiterClock returns a clock that reads the time now points to and a channel
+// that receives a value the first n times the clock is read. A goroutine reads
+// the clock once it waits for stability, so while no stats are ingested and
+// no silent hosts are detected the channel tells that n goroutines wait.
+func waiterClock(now *time.Time, n int) (func() time.Time, <-chan struct{}) {
+	reads := make(chan struct{}, n)
+	return func() time.Time {
+		select {
+		case reads <- struct{}{}:
+		default:
+		}
+		return *now
+	}, reads
+}
+
+func ExampleStatIngester_SilentHosts() {
+	now := time.Date(2016, 6, 15, 16, 11, 8, 0, time.UTC)
+	si := NewStatIngester(nopWriter{})
+	si.SilentAfter = time.Second
+	si.now = func() time.Time { return now }
+	si.IngestStats(bufio.NewScanner(strings.NewReader(stats2)))
+
+	hosts := []string{"172.18.24.220:3000", "172.18.24.220:3001"}
+	fmt.Println(si.SilentHosts(hosts), si.IsClusterStable(hosts))
+
+	now = now.Add(2 * time.Second)
+	stat, _ := ParseStat("2016-06-15T16:11:10.0Z|ringpop.172_18_24_220_3000.ping.send:1|c")
+	si.handleStat(stat)
+	fmt.Println(si.SilentHosts(hosts), si.IsClusterStable(hosts))
 
 	// Output:
+	// [] true
+	// [172.18.24.220:3001] false
 }
 
 var stats2 = `
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+func ExampleParseStat() {
+	for _, line := range []string{
+		"2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:0.593162|ms",
+		"2016-06-15T16:11:08.19884693Z|ringpop.localhost_3000.ping.send:1|c|@0.1",
+		"2016-06-15T16:11:08.19884693Z|orchestrator.stability.flap:1|c",
+		"2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.noise",
+		"2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:fast|ms",
+		"2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:1|h",
+		"2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:1|c|@2",
+		"yesterday|ringpop.172_18_24_220_3007.ping:1|c",
+	} {
+		stat, err := ParseStat(line)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%s %q %q %q %v %s %v\n", stat.Time.Format("15:04:05.000"), stat.Prefix,
+			stat.Hostport, stat.Path, stat.Value, stat.Type, stat.SampleRate)
+	}
+
+	// Output:
+	// 16:11:08.198 "ringpop" "172_18_24_220_3007" "ping" 0.593162 ms 1
+	// 16:11:08.198 "ringpop" "localhost_3000" "ping.send" 1 c 0.1
+	// 16:11:08.198 "" "" "orchestrator.stability.flap" 1 c 1
+	// malformed stat "2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.noise": expected <timestamp>|<path>:<value>|<type>
+	// malformed stat "2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:fast|ms": value is not a number
+	// malformed stat "2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:1|h": unknown type h
+	// malformed stat "2016-06-15T16:11:08.19884693Z|ringpop.172_18_24_220_3007.ping:1|c|@2": sample rate is not in (0, 1]
+	// malformed stat "yesterday|ringpop.172_18_24_220_3007.ping:1|c": invalid timestamp
+}
+
+func ExampleStatScanner() {
+	s := bufio.NewScanner(strings.NewReader(malformedStats))
+	ss := NewStatScanner(s)
+	for ss.Scan() {
+		fmt.Println(ss.Stat().FullPath())
+	}
+	fmt.Println(ss.Err())
+
+	_, err := CountAnalysis(bufio.NewScanner(strings.NewReader(malformedStats)), "ringpop", "ping.send")
+	fmt.Println(err)
+
+	// Output:
+	// ringpop.172_18_24_220_3000.ping.send
+	// ringpop.172_18_24_220_3000.ping.recv
+	// malformed stat "2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.send:1": expected <timestamp>|<path>:<value>|<type>
+	// count analysis
+	// : malformed stat "2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.send:1": expected <timestamp>|<path>:<value>|<type>
+}
+
+var malformedStats = `
+2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.send:1|c
+label:t0|cmd: kill 1
+2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.recv:1|c
+2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.send:1
+2016-06-15T16:11:08.198Z|ringpop.172_18_24_220_3000.ping.send:1|c
+`
@@ -0,0 +1,203 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The file contains the templating of scenarios. The variables that are
+// declared in the runs of a scenario are bound to the values of a run and
+// substituted in the labels, commands, sizes and measures. Expressions between
+// braces are evaluated, e.g. "kill {<N>/2}" becomes "kill 2" when <N> is 4.
+// Note that yaml requires a value that starts with a brace to be quoted.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// variableRegex matches the name of a variable, e.g. <N> or <KILL_COUNT>.
+var variableRegex = regexp.MustCompile(`<[A-Za-z_][A-Za-z0-9_-]*>`)
+
+// A binding is the value of a variable in a run. The value is typed: a
+// float64 for numbers, a time.Duration for durations and a string otherwise.
+type binding struct {
+	text  string
+	value interface{}
+}
+
+// bindings maps the names of variables to their values in a run.
+type bindings map[string]binding
+
+// bind binds the variables to the values of a run.
+func bind(vars, values []string) bindings {
+	b := make(bindings, len(vars))
+	for i := range vars {
+		b[vars[i]] = binding{
+			text:  values[i],
+			value: typedValue(values[i]),
+		}
+	}
+	return b
+}
+
+// with returns the bindings extended with other bindings, the other bindings
+// take precedence.
+func (b bindings) with(other bindings) bindings {
+	result := make(bindings, len(b)+len(other))
+	for name, v := range b {
+		result[name] = v
+	}
+	for name, v := range other {
+		result[name] = v
+	}
+	return result
+}
+
+// typedValue returns the value of str as a number or duration when possible.
+func typedValue(str string) interface{} {
+	if f, err := strconv.ParseFloat(str, 64); err == nil {
+		return f
+	}
+	if d, err := time.ParseDuration(str); err == nil {
+		return d
+	}
+	return str
+}
+
+// expand substitutes the variables in str and evaluates the expressions
+// between braces.
+func (b bindings) expand(str string) (string, error) {
+	var result []string
+	for {
+		open := strings.IndexAny(str, "{}")
+		if open == -1 {
+			break
+		}
+		if str[open] == '}' {
+			return "", errors.New("unexpected '}'")
+		}
+		end := strings.IndexAny(str[open+1:], "{}")
+		if end == -1 || str[open+1+end] == '{' {
+			return "", errors.New("expression is missing a closing '}'")
+		}
+		end += open + 1
+
+		text, err := b.substitute(str[:open])
+		if err != nil {
+			return "", err
+		}
+		value, err := b.evaluate(str[open+1 : end])
+		if err != nil {
+			return "", err
+		}
+		result = append(result, text, value)
+		str = str[end+1:]
+	}
+
+	text, err := b.substitute(str)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append(result, text), ""), nil
+}
+
+// substitute replaces the variables in str by the text of their values.
+func (b bindings) substitute(str string) (string, error) {
+	var err error
+	str = variableRegex.ReplaceAllStringFunc(str, func(name string) string {
+		v, ok := b[name]
+		if !ok {
+			if err == nil {
+				err = unknownVariable(name)
+			}
+			return name
+		}
+		return v.text
+	})
+	return str, err
+}
+
+// evaluate evaluates an expression. The variables can be referred to as <N>
+// or by their name without brackets, e.g. "ceil(log2(N))".
+func (b bindings) evaluate(expr string) (string, error) {
+	var err error
+	expr = variableRegex.ReplaceAllStringFunc(expr, func(name string) string {
+		v, ok := b[name]
+		if !ok {
+			if err == nil {
+				err = unknownVariable(name)
+			}
+			return name
+		}
+		if _, ok := v.value.(string); ok {
+			if err == nil {
+				msg := fmt.Sprintf("variable %s = %s is not a number", name, v.text)
+				err = errors.New(msg)
+			}
+			return name
+		}
+		return identifier(name)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	vars := make(map[string]interface{}, len(b))
+	for name, v := range b {
+		if _, ok := v.value.(string); !ok {
+			vars[identifier(name)] = v.value
+		}
+	}
+
+	v, err := Evaluate(expr, vars)
+	if err != nil {
+		return "", err
+	}
+	if f, ok := v.(float64); ok {
+		return formatNumber(f), nil
+	}
+	// durations and bools
+	return fmt.Sprint(v), nil
+}
+
+// identifier returns the name of a variable in an expression, e.g.
+// <KILL-COUNT> becomes KILL_COUNT.
+func identifier(name string) string {
+	return strings.Replace(strings.Trim(name, "<>"), "-", "_", -1)
+}
+
+func unknownVariable(name string) error {
+	msg := fmt.Sprintf("unknown variable %s", name)
+	return errors.New(msg)
+}
+
+// formatNumber formats whole numbers without a fraction so that the result of
+// an expression can be used as a count, e.g. "kill {<N>/2}".
+func formatNumber(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
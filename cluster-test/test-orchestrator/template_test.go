@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+func Example_bindings() {
+	vars := bind([]string{"<N>", "<NN>", "<T>", "<NAME>"}, []string{"5", "10", "2s", "split"})
+
+	fmt.Println(vars.expand("kill <N>, start <NN>"))
+	fmt.Println(vars.expand("kill {<NN>/2}"))
+	fmt.Println(vars.expand("kill {<N>*2+1}"))
+	fmt.Println(vars.expand("t0 t1 count is {<N>*<NN>} <NAME>"))
+	fmt.Println(vars.expand("{<N>/4}"))
+	fmt.Println(vars.expand("wait-for-stable <T>"))
+	fmt.Println(vars.expand("kill {max(1, <N>/10)}, start {ceil(log2(NN))*3}"))
+	fmt.Println(vars.expand("{<N> > 3 && NN%3 == 1}"))
+	fmt.Println(vars.expand("wait-for-stable {<T>*2 + <N>*100ms}"))
+
+	fmt.Println(vars.expand("kill <M>"))
+	fmt.Println(vars.expand("kill {<M>/2}"))
+	fmt.Println(vars.expand("kill {<NAME>/2}"))
+	fmt.Println(vars.expand("kill {<N>/2"))
+	fmt.Println(vars.expand("kill <N>}"))
+	fmt.Println(vars.expand("kill {<N>+}"))
+
+	// Output:
+	// kill 5, start 10 <nil>
+	// kill 5 <nil>
+	// kill 11 <nil>
+	// t0 t1 count is 50 split <nil>
+	// 1.25 <nil>
+	// wait-for-stable 2s <nil>
+	// kill 1, start 12 <nil>
+	// true <nil>
+	// wait-for-stable 4.5s <nil>
+	//  unknown variable <M>
+	//  unknown variable <M>
+	//  variable <NAME> = split is not a number
+	//  expression is missing a closing '}'
+	//  unexpected '}'
+	//  eval error for expression: "N+"
+}
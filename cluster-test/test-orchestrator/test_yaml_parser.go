@@ -23,70 +23,284 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // testYaml is used to unmarshal test declared in the yaml files.
 type testYaml struct {
-	Config    configYaml
+	Config    yaml.Node
 	Scenarios []*scenarioYaml
+
+	// Include lists the files that declare fragments, see fragmentsYaml.
+	Include   []yaml.Node
+	Fragments map[string]*fragmentYaml
 }
 
+// configYaml captures the settings of the orchestrator, see Config. Unset
+// settings are left empty so that they don't override other settings.
 type configYaml struct {
-	// TODO(wieger): define
+	Binary         string
+	Args           []string
+	Host           string
+	BasePort       string `yaml:"base-port"`
+	Proxy          *bool
+	StatsPort      string `yaml:"stats-port"`
+	StatsDir       string `yaml:"stats-dir"`
+	StatsPrefix    string `yaml:"stats-prefix"`
+	StableTimeout  string `yaml:"stable-timeout"`
+	StableFor      string `yaml:"stable-for"`
+	SilentAfter    string `yaml:"silent-after"`
+	AbortOnFailure *bool  `yaml:"abort-on-failure"`
 }
 
-// scenarioYaml captures the information of a scenario.
+// scenarioYaml captures the information of a scenario. Fields that can
+// contain mistakes are kept as yaml nodes so that errors can point to their
+// position in the yaml.
 type scenarioYaml struct {
 	Name string
-	Size string
+	Size yaml.Node
 	Desc string
 
-	Script  []map[string]string
-	Measure []string
-	Runs    [][]string
+	Script  []yaml.Node
+	Measure []yaml.Node
+	Runs    []yaml.Node
+
+	// Matrix is an alternative to Runs that declares the values of every
+	// variable, a run is made for every combination of values. e.g.
+	//
+	// matrix:
+	//   <N>: [5, 10]
+	//   <DROP>: [10%, 50%]
+	//   exclude:
+	//   - {<N>: 10, <DROP>: 50%}
+	//   include:
+	//   - {<N>: 20, <DROP>: 0%}
+	Matrix yaml.Node
+
+	// Stable lists the criteria that must hold for the cluster to be
+	// stable, e.g. [disseminate, checksums, quiet 2s].
+	Stable []yaml.Node
+
+	// Config overrides the settings of the config section for this
+	// scenario.
+	Config yaml.Node
+
+	// node is the mapping node the scenario is declared in.
+	node *yaml.Node
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to remember the position of the
+// scenario.
+func (s *scenarioYaml) UnmarshalYAML(node *yaml.Node) error {
+	type plain scenarioYaml
+	s.node = node
+	return node.Decode((*plain)(s))
+}
+
+// parse parses the test yaml. When the yaml contains mistakes, all of them
+// are returned as ParseErrors. Included files are relative to the working
+// directory.
+func parse(bts []byte) ([]*Scenario, error) {
+	return parseFile("", bts)
+}
+
+// parseFile parses the test yaml that is read from the named file. Included
+// files are relative to the directory of the file.
+func parseFile(file string, bts []byte) ([]*Scenario, error) {
+	p := &yamlParser{}
+	return p.parseFile(file, bts)
+}
+
+// lintFile parses the test yaml that is read from the named file and checks
+// the scenarios for mistakes that are otherwise only found when the scenarios
+// run, see lintCommand and lintMeasurement. All mistakes are returned as
+// ParseErrors. The flags take precedence over the config in the test yaml,
+// they must be valid.
+func lintFile(file string, bts []byte, flags configYaml) error {
+	p := &yamlParser{lint: true, flags: flags}
+	_, err := p.parseFile(file, bts)
+	return err
+}
+
+func (p *yamlParser) parseFile(file string, bts []byte) ([]*Scenario, error) {
+	scns := p.parseScenarios(file, bts)
+	if len(p.errs) > 0 {
+		// report the errors in the order they appear in the yaml
+		sort.SliceStable(p.errs, func(i, j int) bool {
+			a, b := p.errs[i], p.errs[j]
+			if a.File != b.File {
+				return a.File < b.File
+			}
+			return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+		})
+		return nil, p.errs
+	}
+	return scns, nil
+}
+
+// A yamlParser extracts the scenarios from a test yaml. It doesn't stop at the
+// first mistake but collects the errors so that all of them can be reported.
+type yamlParser struct {
+	errs ParseErrors
+
+	// seen is used to report a mistake only once when it is repeated in
+	// every run of a scenario.
+	seen map[string]bool
+
+	// The fragments that are declared in the test yaml and the files it
+	// includes, and the files that are included.
+	fragments map[string]*fragmentYaml
+	included  map[string]bool
+
+	// lint enables the static checks of the scenarios. The origins of the
+	// commands and measurements are kept to report the mistakes.
+	lint    bool
+	origins map[interface{}]origin
+
+	// The settings of the command-line flags that the scenarios are linted
+	// with.
+	flags configYaml
+}
+
+// origin is the position of a command or measurement in the test yaml.
+type origin struct {
+	sc   scope
+	node *yaml.Node
+	text string
 }
 
-func parse(bts []byte) (scns []*Scenario, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			scns = nil
-			err = errors.New(fmt.Sprint(r))
+// setOrigin remembers the origin of a command or measurement when linting.
+func (p *yamlParser) setOrigin(v interface{}, sc scope, node *yaml.Node, text string) {
+	if !p.lint {
+		return
+	}
+	if p.origins == nil {
+		p.origins = make(map[interface{}]origin)
+	}
+	p.origins[v] = origin{sc, node, text}
+}
+
+// lintScenario records the mistakes that are found by the static checks.
+func (p *yamlParser) lintScenario(s *Scenario) {
+	// invalid flags are reported before linting, see lintFile
+	config, err := s.Config.merge(p.flags)
+	if err != nil {
+		config = s.Config
+	}
+	for _, cmd := range s.Script {
+		if err := lintCommand(cmd, config, s.Size); err != nil {
+			o := p.origins[cmd]
+			p.addError(o.sc, o.node, o.text, err)
+		}
+	}
+	for _, m := range s.Measure {
+		if err := lintMeasurement(m, s.Script); err != nil {
+			o := p.origins[m]
+			p.addError(o.sc, o.node, o.text, err)
 		}
-	}()
+	}
+}
 
-	return parseScenarios(bts), nil
+// scope describes which part of the test yaml is being parsed.
+type scope struct {
+	file     string
+	scenario string
+	run      int
+	field    string
 }
 
-func parseScenarios(bts []byte) []*Scenario {
+// addError records an error at the position of the node. The text is the
+// offending text after variable substitution.
+func (p *yamlParser) addError(sc scope, node *yaml.Node, text string, err error) {
+	e := &ParseError{
+		File:     sc.file,
+		Scenario: sc.scenario,
+		Run:      sc.run,
+		Field:    sc.field,
+		Text:     text,
+		Msg:      err.Error(),
+	}
+	if node != nil {
+		e.Line, e.Column = node.Line, node.Column
+	}
+
+	key := fmt.Sprintf("%s:%d:%d:%s:%s", e.File, e.Line, e.Column, e.Text, e.Msg)
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+	if p.seen[key] {
+		return
+	}
+	p.seen[key] = true
+	p.errs = append(p.errs, e)
+}
+
+// scalar returns the value of a node that should hold a single value.
+func (p *yamlParser) scalar(sc scope, node *yaml.Node) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		p.addError(sc, node, "", errors.New("should be a single value"))
+		return "", false
+	}
+	return node.Value, true
+}
+
+func (p *yamlParser) parseScenarios(file string, bts []byte) []*Scenario {
 	testYaml := &testYaml{}
-	err := yaml.Unmarshal([]byte(bts), testYaml)
+	err := yaml.Unmarshal(bts, testYaml)
 	if err != nil {
-		panic("failed to unmarshal scenario yaml")
+		p.errs = append(p.errs, yamlParseErrors(file, err)...)
+
+		// A type error leaves the rest of the yaml intact, other errors
+		// mean the yaml couldn't be read at all.
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return nil
+		}
 	}
 
-	return extractScenarios(testYaml)
+	p.addFragments(file, testYaml.Include, testYaml.Fragments)
+	return p.extractScenarios(file, testYaml)
 }
 
 // extractScenarios returns a scenario for every element in the runs list.
-func extractScenarios(runs *testYaml) []*Scenario {
+func (p *yamlParser) extractScenarios(file string, runs *testYaml) []*Scenario {
+	config := p.parseConfig(scope{file: file, field: "config"}, defaultConfig(), &runs.Config)
+
 	var result []*Scenario
 	for _, scenarioData := range runs.Scenarios {
-		for _, vari := range scenarioData.Runs[0] {
-			if vari[0] != '<' || vari[len(vari)-1] != '>' {
-				panic(fmt.Sprintf("variable '%s' not of the form <var>", vari))
-			}
+		sc := scope{file: file, scenario: scenarioData.Name}
+
+		var vars []string
+		var scenarioRuns []*runYaml
+		switch {
+		case scenarioData.Matrix.Kind != 0 && len(scenarioData.Runs) > 0:
+			p.addError(sc, scenarioData.node, "", errors.New("scenario should declare either runs or a matrix"))
+			continue
+		case scenarioData.Matrix.Kind != 0:
+			vars, scenarioRuns = p.extractMatrix(sc, scenarioData)
+		default:
+			vars, scenarioRuns = p.extractRuns(sc, scenarioData)
 		}
-		// We start at i=1 because the first entry of the runs declares the
-		// variables names. e.g. [<N>, <A>, <B>].
-		for i := 1; i < len(scenarioData.Runs); i++ {
-			s := extractScenario(scenarioData, i)
+
+		sc.field = "config"
+		scenarioConfig := p.parseConfig(sc, config, &scenarioData.Config)
+
+		for _, run := range scenarioRuns {
+			s := p.extractScenario(file, scenarioData, vars, run)
+			if s == nil {
+				continue
+			}
+			s.Config = scenarioConfig
+			if p.lint {
+				p.lintScenario(s)
+			}
 			result = append(result, s)
 		}
 	}
@@ -94,110 +308,575 @@ func extractScenarios(runs *testYaml) []*Scenario {
 	return result
 }
 
-// extractScenario returns a scenario given the index of a specific run.
-func extractScenario(data *scenarioYaml, runIx int) *Scenario {
-	varsData := data.Runs[0]
-	runData := data.Runs[runIx]
-	defer wrapPanicf("Failed to parse scenario '%s'", data.Name)
-	defer wrapPanicf("in run %d, [%v] = [%v]", runIx, strings.Join(varsData, ", "), strings.Join(runData, ", "))
+// runYaml is a single run of a scenario.
+type runYaml struct {
+	// The index of the run starting at 1.
+	ix int
+
+	// The name of the scenario of the run.
+	name string
+
+	// The values of the variables of the scenario.
+	values []string
+}
+
+// extractRuns returns the variables and runs that are declared in the runs
+// list.
+func (p *yamlParser) extractRuns(sc scope, data *scenarioYaml) ([]string, []*runYaml) {
+	sc.field = "runs"
+	if len(data.Runs) == 0 {
+		p.addError(sc, data.node, "", errors.New("scenario has no runs"))
+		return nil, nil
+	}
+
+	vars, ok := p.extractVars(sc, &data.Runs[0])
+	if !ok {
+		return nil, nil
+	}
+
+	// We start at i=1 because the first entry of the runs declares the
+	// variables names. e.g. [<N>, <A>, <B>].
+	var runs []*runYaml
+	for i := 1; i < len(data.Runs); i++ {
+		sc.run = i
+		runNode := &data.Runs[i]
+		values, ok := p.extractValues(sc, runNode)
+		if !ok {
+			continue
+		}
+		if len(vars) != len(values) {
+			msg := fmt.Sprintf("var count of run %v should match var count of %v", values, vars)
+			p.addError(sc, runNode, "", errors.New(msg))
+			continue
+		}
+		runs = append(runs, &runYaml{ix: i, name: data.Name, values: values})
+	}
+	return vars, runs
+}
+
+// extractVars returns the variable names that are declared in the first
+// entry of the runs list.
+func (p *yamlParser) extractVars(sc scope, node *yaml.Node) ([]string, bool) {
+	vars, ok := p.extractValues(sc, node)
+	if !ok {
+		return nil, false
+	}
+	declared := make(map[string]bool, len(vars))
+	for i := range vars {
+		ok = p.declareVar(sc, node.Content[i], declared) && ok
+	}
+	return vars, ok
+}
+
+// declareVar checks the name of a variable and adds it to the declared
+// variables.
+func (p *yamlParser) declareVar(sc scope, node *yaml.Node, declared map[string]bool) bool {
+	vari := node.Value
+	var msg string
+	switch {
+	case variableRegex.FindString(vari) != vari:
+		msg = fmt.Sprintf("variable '%s' not of the form <var>", vari)
+	case declared[vari]:
+		msg = fmt.Sprintf("variable '%s' is declared twice", vari)
+	default:
+		declared[vari] = true
+		return true
+	}
+	p.addError(sc, node, vari, errors.New(msg))
+	return false
+}
+
+// extractValues returns the values of a list, e.g. an entry of the runs list.
+func (p *yamlParser) extractValues(sc scope, node *yaml.Node) ([]string, bool) {
+	if node.Kind != yaml.SequenceNode {
+		p.addError(sc, node, "", errors.New("should be a list of values"))
+		return nil, false
+	}
+
+	ok := true
+	values := make([]string, len(node.Content))
+	for i, n := range node.Content {
+		var valid bool
+		values[i], valid = p.scalar(sc, n)
+		ok = ok && valid
+	}
+	return values, ok
+}
+
+// extractMatrix returns the variables and runs of a matrix. A run is made
+// for every combination of the values of the variables, without the
+// combinations that match an entry of exclude and with the combinations of
+// include. The values of a run are added to the scenario name, e.g.
+// "kill[N=5,DROP=10%]".
+func (p *yamlParser) extractMatrix(sc scope, data *scenarioYaml) ([]string, []*runYaml) {
+	sc.field = "matrix"
+	node := &data.Matrix
+	if node.Kind != yaml.MappingNode {
+		p.addError(sc, node, "", errors.New("should map variables to their values"))
+		return nil, nil
+	}
+
+	var vars []string
+	var values [][]string
+	var exclude, include *yaml.Node
+	declared := make(map[string]bool)
+	ok := true
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "exclude":
+			exclude = value
+		case "include":
+			include = value
+		default:
+			ok = p.declareVar(sc, key, declared) && ok
+			vs, valid := p.extractValues(sc, value)
+			ok = valid && ok
+			vars = append(vars, key.Value)
+			values = append(values, vs)
+		}
+	}
+
+	excluded, valid := p.extractAssignments(sc, exclude, vars, false)
+	ok = valid && ok
+	included, valid := p.extractAssignments(sc, include, vars, true)
+	ok = valid && ok
+	if !ok {
+		return nil, nil
+	}
+	if len(vars) == 0 {
+		p.addError(sc, node, "", errors.New("matrix declares no variables"))
+		return nil, nil
+	}
 
-	if len(varsData) != len(runData) {
-		msg := fmt.Sprintf("var count of run %v should match var count of %v", runData, varsData)
-		panic(msg)
+	var combinations [][]string
+	for _, combination := range product(values) {
+		if !matchesAny(excluded, vars, combination) {
+			combinations = append(combinations, combination)
+		}
+	}
+	for _, assignment := range included {
+		combination := make([]string, len(vars))
+		for i, vari := range vars {
+			combination[i] = assignment[vari]
+		}
+		if !matchesAny([]map[string]string{assignment}, vars, combinations...) {
+			combinations = append(combinations, combination)
+		}
 	}
 
+	runs := make([]*runYaml, len(combinations))
+	for i, combination := range combinations {
+		parts := make([]string, len(vars))
+		for j, vari := range vars {
+			parts[j] = strings.Trim(vari, "<>") + "=" + combination[j]
+		}
+		runs[i] = &runYaml{
+			ix:     i + 1,
+			name:   fmt.Sprintf("%s[%s]", data.Name, strings.Join(parts, ",")),
+			values: combination,
+		}
+	}
+	return vars, runs
+}
+
+// extractAssignments returns the entries of the exclude or include list of a
+// matrix, every entry assigns values to variables. The entries of include
+// must assign a value to every variable.
+func (p *yamlParser) extractAssignments(sc scope, node *yaml.Node, vars []string, complete bool) ([]map[string]string, bool) {
+	if node == nil {
+		return nil, true
+	}
+	if node.Kind != yaml.SequenceNode {
+		p.addError(sc, node, "", errors.New("should be a list of variable assignments"))
+		return nil, false
+	}
+
+	declared := make(map[string]bool, len(vars))
+	for _, vari := range vars {
+		declared[vari] = true
+	}
+
+	ok := true
+	var assignments []map[string]string
+	for _, entry := range node.Content {
+		if entry.Kind != yaml.MappingNode {
+			p.addError(sc, entry, "", errors.New("should map variables to a value"))
+			ok = false
+			continue
+		}
+
+		assignment := make(map[string]string)
+		for i := 0; i+1 < len(entry.Content); i += 2 {
+			key, value := entry.Content[i], entry.Content[i+1]
+			if !declared[key.Value] {
+				msg := fmt.Sprintf("unknown variable %s", key.Value)
+				p.addError(sc, key, key.Value, errors.New(msg))
+				ok = false
+				continue
+			}
+			if v, valid := p.scalar(sc, value); valid {
+				assignment[key.Value] = v
+			} else {
+				ok = false
+			}
+		}
+
+		if complete && len(assignment) != len(vars) {
+			for _, vari := range vars {
+				if _, assigned := assignment[vari]; !assigned {
+					msg := fmt.Sprintf("include should assign a value to every variable, %s is missing", vari)
+					p.addError(sc, entry, "", errors.New(msg))
+					ok = false
+					break
+				}
+			}
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, ok
+}
+
+// product returns every combination of values, the values of the first
+// variable change the slowest.
+func product(values [][]string) [][]string {
+	combinations := [][]string{{}}
+	for _, vs := range values {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, v := range vs {
+				c := make([]string, len(combination), len(combination)+1)
+				copy(c, combination)
+				next = append(next, append(c, v))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// matchesAny returns whether one of the combinations matches one of the
+// assignments. A combination matches an assignment when the variables that
+// are assigned have the value of the assignment.
+func matchesAny(assignments []map[string]string, vars []string, combinations ...[]string) bool {
+	for _, assignment := range assignments {
+		for _, combination := range combinations {
+			matches := true
+			for i, vari := range vars {
+				if v, assigned := assignment[vari]; assigned && v != combination[i] {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractScenario returns the scenario of a run. It returns nil when the
+// scenario contains mistakes.
+func (p *yamlParser) extractScenario(file string, data *scenarioYaml, varsData []string, run *runYaml) *Scenario {
+	errCount := len(p.errs)
+	sc := scope{file: file, scenario: data.Name, run: run.ix}
+	runData := run.values
+
+	vars := bind(varsData, runData)
+
 	// don't find and replace on name
-	name := data.Name
-	desc := replace(data.Desc, varsData, runData)
-	sizeStr := replace(data.Size, varsData, runData)
+	name := run.name
+	sc.field = "desc"
+	desc, _ := p.expand(sc, data.node, data.Desc, vars)
 
 	// extract size
-	size, err := strconv.Atoi(sizeStr)
-	if err != nil {
-		panic("size convert: " + err.Error())
+	sc.field = "size"
+	var size int
+	if data.Size.Kind == 0 {
+		p.addError(sc, data.node, "", errors.New("scenario has no size"))
+	} else if sizeStr, ok := p.scalar(sc, &data.Size); ok {
+		sizeStr, ok = p.expand(sc, &data.Size, sizeStr, vars)
+		var err error
+		if size, err = strconv.Atoi(sizeStr); ok && err != nil {
+			p.addError(sc, &data.Size, sizeStr, errors.New("size is not a number"))
+		}
 	}
 
 	// extract script
-	labels, cmds := extractScript(data.Script, varsData, runData)
-	script := parseScript(labels, cmds)
+	sc.field = "script"
+	script := p.extractScript(sc, data.Script, vars)
 
 	// extract Measure
-	measureStrs := make([]string, len(data.Measure))
+	sc.field = "measure"
+	var measure []*Measurement
 	for i := range data.Measure {
-		measureStrs[i] = replace(data.Measure[i], varsData, runData)
+		node := &data.Measure[i]
+		str, ok := p.scalar(sc, node)
+		if !ok {
+			continue
+		}
+		if str, ok = p.expand(sc, node, str, vars); !ok {
+			continue
+		}
+		m, err := parseMeasurement(str)
+		if err == nil {
+			m.Iterations, err = countIterations(m, script)
+		}
+		if err != nil {
+			p.addError(sc, node, str, err)
+			continue
+		}
+		p.setOrigin(m, sc, node, str)
+		measure = append(measure, m)
+	}
+
+	// extract stability criteria
+	sc.field = "stable"
+	var stable []StabilityCriterion
+	for i := range data.Stable {
+		node := &data.Stable[i]
+		str, ok := p.scalar(sc, node)
+		if !ok {
+			continue
+		}
+		if str, ok = p.expand(sc, node, str, vars); !ok {
+			continue
+		}
+		c, err := parseStabilityCriterion(str)
+		if err != nil {
+			p.addError(sc, node, str, err)
+			continue
+		}
+		stable = append(stable, c)
+	}
+
+	if len(p.errs) > errCount {
+		return nil
+	}
+
+	runVars := make(map[string]string, len(varsData))
+	for i, name := range varsData {
+		runVars[strings.Trim(name, "<>")] = runData[i]
 	}
-	measure := parseMeasurements(measureStrs)
 
 	return &Scenario{
 		Name:    name,
+		Vars:    runVars,
 		Desc:    desc,
 		Size:    size,
 		Script:  script,
 		Measure: measure,
+		Stable:  stable,
 	}
 }
 
-func extractScript(script []map[string]string, varsData, runData []string) (labels, cmds []string) {
-	labels = make([]string, 0, len(script))
-	cmds = make([]string, 0, len(script))
-	for _, cmdData := range script {
-		if len(cmdData) != 1 {
-			// We are asserting that commands are one line only to comply with
-			// the yaml that looks like:
-			//
-			// script:
-			// - t0: command1
-			// - t1: command2
-			panic(fmt.Sprintf("command '%v' should contain exactly one entry", cmdData))
+// parseConfig returns the base Config with the settings of the config node
+// applied. On a mistake the error is recorded and the base is returned.
+func (p *yamlParser) parseConfig(sc scope, base *Config, node *yaml.Node) *Config {
+	if node.Kind == 0 {
+		return base
+	}
+
+	var data configYaml
+	if err := node.Decode(&data); err != nil {
+		for _, e := range yamlParseErrors(sc.file, err) {
+			p.addError(sc, &yaml.Node{Line: e.Line}, "", errors.New(e.Msg))
 		}
+		return base
+	}
+
+	config, err := base.merge(data)
+	if err != nil {
+		pos := node
+		text := ""
+		if settingErr, ok := err.(*SettingError); ok {
+			pos = mappingValue(node, settingErr.Setting)
+			text = pos.Value
+		}
+		p.addError(sc, pos, text, err)
+		return base
+	}
+	return config
+}
+
+// expand substitutes the variables of the run in str and evaluates the
+// expressions between braces, see bindings.expand.
+func (p *yamlParser) expand(sc scope, node *yaml.Node, str string, vars bindings) (string, bool) {
+	result, err := vars.expand(str)
+	if err != nil {
+		p.addError(sc, node, str, err)
+		return "", false
+	}
+	return result, true
+}
 
-		for label, cmd := range cmdData {
-			labels = append(labels, replace(label, varsData, runData))
-			cmds = append(cmds, replace(cmd, varsData, runData))
+// mappingValue returns the value of the key in a mapping node. The mapping
+// node itself is returned when the key is not found.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
 		}
 	}
-	return labels, cmds
+	return node
 }
 
-func parseScript(labels, cmdStrs []string) []*Command {
-	defer wrapPanicf("in parse script")
+// extractScript returns the commands of the script. Every command in the
+// script should be of the form "label: command". Fragments that are invoked
+// in the script are expanded, see fragmentYaml.
+func (p *yamlParser) extractScript(sc scope, script []yaml.Node, vars bindings) []*Command {
 	var cmds []*Command
-	for i := range labels {
-		cmd := parseCommand(labels[i], cmdStrs[i])
-		cmds = append(cmds, cmd)
+	labels := make(map[string]bool)
+	for i := range script {
+		node := &script[i]
+		for _, cmd := range p.extractEntry(sc, node, vars, nil) {
+			if labels[cmd.Label] {
+				p.addError(sc, node, cmd.Label, errors.New("label is used more than once"))
+			}
+			labels[cmd.Label] = true
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// extractEntry returns the commands of an entry of a script. The stack holds
+// the names of the fragments the entry is part of.
+func (p *yamlParser) extractEntry(sc scope, node *yaml.Node, vars bindings, stack []string) []*Command {
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		// We are asserting that commands are one line only to comply with
+		// the yaml that looks like:
+		//
+		// script:
+		// - t0: command1
+		// - t1: command2
+		p.addError(sc, node, "", errors.New("command should contain exactly one entry"))
+		return nil
+	}
+
+	label, ok := p.scalar(sc, node.Content[0])
+	if !ok {
+		return nil
+	}
+	if label, ok = p.expand(sc, node.Content[0], label, vars); !ok {
+		return nil
+	}
+
+	if node.Content[1].Kind == yaml.MappingNode {
+		return p.extractRepeat(sc, label, node.Content[1], vars, stack)
+	}
+
+	cmdStr, ok := p.scalar(sc, node.Content[1])
+	if !ok {
+		return nil
+	}
+	if cmdStr, ok = p.expand(sc, node.Content[1], cmdStr, vars); !ok {
+		return nil
+	}
+	cmd, err := parseCommand(label, cmdStr)
+	if err != nil {
+		p.addError(sc, node.Content[1], label+": "+cmdStr, err)
+		return nil
 	}
 
+	if fragment, ok := p.fragments[cmd.Cmd]; ok {
+		return p.expandFragment(sc, node.Content[1], fragment, cmd, vars, stack)
+	}
+	p.setOrigin(cmd, sc, node.Content[1], cmdStr)
+	return []*Command{cmd}
+}
+
+// repeatYaml captures a repeat block in a script, e.g.
+//
+//	script:
+//	- cycle:
+//	    repeat: 10
+//	    script:
+//	    - kill: kill 1
+//	    - start: start 1
+//
+// The commands of every iteration are labelled with the label of the block,
+// the iteration starting at 1 and their own label, e.g. cycle.3.kill. A "*"
+// in the labels of a measurement stands for every iteration, see
+// Measurement.Iterations.
+type repeatYaml struct {
+	Repeat yaml.Node
+	Script []yaml.Node
+}
+
+// extractRepeat returns the commands of a repeat block.
+func (p *yamlParser) extractRepeat(sc scope, label string, node *yaml.Node, vars bindings, stack []string) []*Command {
+	var data repeatYaml
+	if err := node.Decode(&data); err != nil {
+		for _, e := range yamlParseErrors(sc.file, err) {
+			p.addError(sc, &yaml.Node{Line: e.Line}, label, errors.New(e.Msg))
+		}
+		return nil
+	}
+	if data.Repeat.Kind == 0 {
+		p.addError(sc, node, label, errors.New("block should be a repeat block with a repeat count"))
+		return nil
+	}
+
+	countStr, ok := p.scalar(sc, &data.Repeat)
+	if !ok {
+		return nil
+	}
+	if countStr, ok = p.expand(sc, &data.Repeat, countStr, vars); !ok {
+		return nil
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		p.addError(sc, &data.Repeat, countStr, errors.New("repeat count should be a positive number"))
+		return nil
+	}
+
+	var cmds []*Command
+	for i := 1; i <= count; i++ {
+		for j := range data.Script {
+			for _, cmd := range p.extractEntry(sc, &data.Script[j], vars, stack) {
+				cmd.Label = fmt.Sprintf("%s.%d.%s", label, i, cmd.Label)
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
 	return cmds
 }
 
-func parseCommand(label, cmdString string) *Command {
-	defer wrapPanicf("in parse command '%s: %s'", label, cmdString)
+func parseCommand(label, cmdString string) (*Command, error) {
 	fields := strings.Fields(cmdString)
 	if len(fields) == 0 {
-		panic("empty command")
+		return nil, errors.New("empty command")
 	}
 
 	return &Command{
 		Label: label,
 		Cmd:   fields[0],
 		Args:  fields[1:],
-	}
+	}, nil
 }
 
-func parseMeasurements(msData []string) []*Measurement {
-	var ms []*Measurement
-	for _, mData := range msData {
-		ms = append(ms, parseMeasurement(mData))
+func parseMeasurement(str string) (*Measurement, error) {
+	fields := strings.Fields(str)
+
+	// the measure line can start with its severity
+	severity := SeverityRequire
+	if len(fields) > 0 && (fields[0] == string(SeverityRequire) || fields[0] == string(SeverityExpect)) {
+		severity = Severity(fields[0])
+		fields = fields[1:]
 	}
-	return ms
-}
 
-func parseMeasurement(str string) *Measurement {
-	defer wrapPanicf("in parse measure '%s'", str)
+	// the quantity can be measured per node
+	perNode := len(fields) > 2 && fields[2] == "per-node"
+	if perNode {
+		fields = append(fields[:2], fields[3:]...)
+	}
 
-	fields := strings.Fields(str)
 	if len(fields) < 3 {
-		panic("contains too few fields")
+		return nil, errors.New("contains too few fields")
 	}
 
 	measurementArgs := fields[3:]
@@ -205,103 +884,359 @@ func parseMeasurement(str string) *Measurement {
 	// search for optional assertion
 	var assertion *Assertion
 	for i, s := range measurementArgs {
-		if s == "is" || s == "in" {
-			interval := strings.Join(measurementArgs[i+1:], "")
-			assertion = parseAssertion(s, interval)
+		if assertionKeywords[s] || s == "not" || strings.HasPrefix(s, "(") {
+			var err error
+			assertion, err = parseAssertions(strings.Join(measurementArgs[i:], " "))
+			if err != nil {
+				return nil, err
+			}
 			measurementArgs = measurementArgs[:i]
+			break
 		}
 	}
 
+	// the values of the nodes can be aggregated, e.g. per-node convtime max
+	var aggregate string
+	if n := len(measurementArgs); perNode && n > 0 && aggregates[measurementArgs[n-1]] != nil {
+		aggregate = measurementArgs[n-1]
+		measurementArgs = measurementArgs[:n-1]
+	}
+
 	return &Measurement{
 		Start:     fields[0],
 		End:       fields[1],
 		Quantity:  fields[2],
 		Args:      measurementArgs,
 		Assertion: assertion,
+		Severity:  severity,
+		PerNode:   perNode,
+		Aggregate: aggregate,
+	}, nil
+}
+
+// countIterations returns the number of iterations of the repeat block a
+// measurement refers to with a "*" in its labels, or zero when it doesn't
+// refer to a repeat block.
+func countIterations(m *Measurement, script []*Command) (int, error) {
+	if !strings.Contains(m.Start+m.End, "*") {
+		return 0, nil
+	}
+
+	labels := make(map[string]bool, len(script))
+	for _, cmd := range script {
+		labels[cmd.Label] = true
+	}
+	exists := func(label string) bool {
+		return label == scriptStartLabel || labels[label]
 	}
+
+	n := 0
+	for it := m.Iteration(n + 1); exists(it.Start) && exists(it.End); it = m.Iteration(n + 1) {
+		n++
+	}
+	if n == 0 {
+		msg := fmt.Sprintf("labels %s and %s match no iteration of a repeat block", m.Start, m.End)
+		return 0, errors.New(msg)
+	}
+	return n, nil
 }
 
-func parseAssertion(typeStr string, arg string) *Assertion {
-	defer wrapPanicf("in parse assertion '%s %s'", typeStr, arg)
+// assertionKeywords are the words an assertion can start with, see
+// parseAssertion.
+var assertionKeywords = map[string]bool{
+	"is": true, "in": true, "<": true, "<=": true, ">": true, ">=": true,
+	"!=": true, "≈": true, "~=": true, "within": true,
+}
 
-	switch typeStr {
-	case "is":
-		typ := AssertionTypeIs
-		v := parseValue(arg)
-		return &Assertion{
-			Type: typ,
-			V1:   v,
-		}
+// parseAssertion parses an assertion that starts with the keyword typeStr,
+// e.g. "is 4", "in (1s, 2s)", "< 5", "≈ 10 ± 2" or "within 10% of 2s". The
+// ASCII forms "~=" and "+-" can be used instead of "≈" and "±".
+func parseAssertion(typeStr string, arg string) (*Assertion, error) {
+	a, err := parseAssertionArg(AssertionType(typeStr), arg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "assertion '%s %s'", typeStr, arg)
+	}
+	return a, nil
+}
 
-	case "in":
-		typ := AssertionTypeIn
-		v1, v2 := parseRange(arg)
-		return &Assertion{
-			Type: typ,
-			V1:   v1,
-			V2:   v2,
+// parseAssertions parses assertions that are combined with "and", "or" and
+// "not" and grouped by parenthesis, e.g. "is 1 or (> 5 and not is 10)". The
+// "and" binds stronger than the "or" and an operand without keyword checks
+// for equality, e.g. "in (1, 3) and not 2".
+func parseAssertions(str string) (*Assertion, error) {
+	p := &assertionParser{str: str, tokens: assertionTokens(str)}
+	a, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		msg := fmt.Sprintf("assertion '%s': unexpected '%s'", str, p.peek())
+		return nil, errors.New(msg)
+	}
+	return a, nil
+}
+
+// assertionToken is a word or a parenthesis of an assertion, start and end
+// are the offsets of the token in the assertion.
+type assertionToken struct {
+	start, end int
+}
+
+// assertionTokens splits an assertion into words that are separated by white
+// space and the parenthesis around them.
+func assertionTokens(str string) []assertionToken {
+	var tokens []assertionToken
+	start := -1
+	for i, r := range str {
+		if start >= 0 && (unicode.IsSpace(r) || r == '(' || r == ')') {
+			tokens = append(tokens, assertionToken{start, i})
+			start = -1
 		}
+		switch {
+		case r == '(' || r == ')':
+			tokens = append(tokens, assertionToken{i, i + 1})
+		case !unicode.IsSpace(r) && start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, assertionToken{start, len(str)})
 	}
+	return tokens
+}
 
-	panic("not valid assertion type")
+// assertionParser is a recursive descent parser of combined assertions.
+type assertionParser struct {
+	str    string
+	tokens []assertionToken
+	pos    int
 }
 
-func parseRange(rng string) (v1, v2 Value) {
-	defer wrapPanicf("in parse range '%s'", rng)
+// peek returns the current token or an empty string at the end of the
+// assertion.
+func (p *assertionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	t := p.tokens[p.pos]
+	return p.str[t.start:t.end]
+}
+
+func (p *assertionParser) parseOr() (*Assertion, error) {
+	return p.parseCombination(AssertionTypeOr, p.parseAnd)
+}
 
-	if rng[0] != '(' || rng[len(rng)-1] != ')' {
-		panic("should be enclosed by parenthesis")
+func (p *assertionParser) parseAnd() (*Assertion, error) {
+	return p.parseCombination(AssertionTypeAnd, p.parseOperand)
+}
+
+// parseCombination parses operands that are separated by the keyword of typ.
+func (p *assertionParser) parseCombination(typ AssertionType, parseOperand func() (*Assertion, error)) (*Assertion, error) {
+	a, err := parseOperand()
+	if err != nil {
+		return nil, err
 	}
-	split := strings.Split(rng[1:len(rng)-1], ",")
-	if len(split) != 2 {
-		panic("should be split by a comma")
+	operands := []*Assertion{a}
+	for p.peek() == string(typ) {
+		p.pos++
+		a, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, a)
+	}
+	if len(operands) == 1 {
+		return a, nil
 	}
+	return &Assertion{Type: typ, Operands: operands}, nil
+}
 
-	v1 = parseValue(split[0])
-	v2 = parseValue(split[1])
+func (p *assertionParser) parseOperand() (*Assertion, error) {
+	switch p.peek() {
+	case "":
+		msg := fmt.Sprintf("assertion '%s': missing operand", p.str)
+		return nil, errors.New(msg)
+	case "not":
+		p.pos++
+		a, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &Assertion{Type: AssertionTypeNot, Operands: []*Assertion{a}}, nil
+	case "(":
+		p.pos++
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			msg := fmt.Sprintf("assertion '%s': missing ')'", p.str)
+			return nil, errors.New(msg)
+		}
+		p.pos++
+		return a, nil
+	}
 
-	if reflect.TypeOf(v1) != reflect.TypeOf(v2) {
-		panic(fmt.Sprintf("types %T %T should be equal", v1, v2))
+	typeStr := string(AssertionTypeIs)
+	if assertionKeywords[p.peek()] {
+		typeStr = p.peek()
+		p.pos++
 	}
 
-	return v1, v2
+	// the argument ends at an "and", "or" or ")" that is not enclosed by
+	// parenthesis of the argument itself
+	first, depth := p.pos, 0
+	for ; p.pos < len(p.tokens); p.pos++ {
+		tok := p.peek()
+		if depth == 0 && (tok == "and" || tok == "or" || tok == ")") {
+			break
+		}
+		switch tok {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+	}
+	if p.pos == first {
+		msg := fmt.Sprintf("assertion '%s': missing value after '%s'", p.str, typeStr)
+		return nil, errors.New(msg)
+	}
+	arg := p.str[p.tokens[first].start:p.tokens[p.pos-1].end]
+	return parseAssertion(typeStr, arg)
 }
 
-func parseValue(str string) Value {
-	defer wrapPanicf("in parse value '%s", str)
+func parseAssertionArg(typ AssertionType, arg string) (*Assertion, error) {
+	switch typ {
+	case AssertionTypeIs, AssertionTypeLess, AssertionTypeLessEqual,
+		AssertionTypeGreater, AssertionTypeGreaterEqual, AssertionTypeNotEqual:
+		v, err := parseValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &Assertion{Type: typ, V1: v}, nil
 
-	// First check if the input is a number or expression.
-	v, err := Eval(str)
-	if err == nil {
-		return v
+	case AssertionTypeIn:
+		v1, v2, err := parseRange(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &Assertion{Type: typ, V1: v1, V2: v2}, nil
+
+	case AssertionTypeApprox, "~=":
+		split := strings.Split(strings.Replace(arg, "+-", "±", 1), "±")
+		if len(split) != 2 {
+			return nil, errors.New("approximation should be of the form ≈ x ± y")
+		}
+		v, err := parseValue(split[0])
+		if err != nil {
+			return nil, err
+		}
+		tolerance, err := parseValue(split[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Assertion{Type: AssertionTypeApprox, V1: v, V2: zeroAsDuration(tolerance, v)}, nil
+
+	case AssertionTypeWithin:
+		split := strings.SplitN(arg, " of ", 2)
+		percentage := strings.TrimSpace(split[0])
+		if len(split) != 2 || !strings.HasSuffix(percentage, "%") {
+			return nil, errors.New("should be of the form within x% of y")
+		}
+		percent, err := Eval(strings.TrimSuffix(percentage, "%"))
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseValue(split[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Assertion{Type: typ, V1: v, V2: percent}, nil
 	}
 
-	// Then check if the input is a duration. Duration check needs
-	// to be after Eval to prevent "0" to parse as a duration.
-	d, err := time.ParseDuration(str)
-	if err == nil {
-		return Value(d)
+	msg := fmt.Sprintf("'%s' is not a valid assertion type", typ)
+	return nil, errors.New(msg)
+}
+
+// parseRange parses an interval like "(1s, 2s)". One of the bounds can be
+// left out for an interval that is unbounded on that side, e.g. "(1s, )".
+func parseRange(rng string) (v1, v2 Value, err error) {
+	rng = strings.TrimSpace(rng)
+	if len(rng) < 2 || rng[0] != '(' || rng[len(rng)-1] != ')' {
+		return nil, nil, errors.New("range should be enclosed by parenthesis")
+	}
+	split := splitTopLevel(rng[1:len(rng)-1], ',')
+	if len(split) != 2 {
+		return nil, nil, errors.New("range should be split by a comma")
 	}
+	if strings.TrimSpace(split[0]) == "" && strings.TrimSpace(split[1]) == "" {
+		return nil, nil, errors.New("range should have at least one bound")
+	}
+
+	if strings.TrimSpace(split[0]) != "" {
+		if v1, err = parseValue(split[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if strings.TrimSpace(split[1]) != "" {
+		if v2, err = parseValue(split[1]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if v1 == nil || v2 == nil {
+		return v1, v2, nil
+	}
+
+	// a range from zero doesn't require a unit, e.g. (0, <N>*200ms)
+	v1, v2 = zeroAsDuration(v1, v2), zeroAsDuration(v2, v1)
 
-	panic("value is not a number duration or expression")
+	if !isBaselineExpr(v1) && !isBaselineExpr(v2) && reflect.TypeOf(v1) != reflect.TypeOf(v2) {
+		msg := fmt.Sprintf("range types %T %T should be equal", v1, v2)
+		return nil, nil, errors.New(msg)
+	}
+
+	return v1, v2, nil
 }
 
-// replace finds occurrences of varsData and replaces them by the respective
-// element in the runsData.
-func replace(str string, varsData []string, runData []string) string {
-	for i := range varsData {
-		str = strings.Replace(str, varsData[i], runData[i], -1)
+// splitTopLevel splits str around the separators that are not enclosed by
+// parentheses, e.g. the comma in "max(1, 2), 3".
+func splitTopLevel(str string, sep rune) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range str {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, str[start:i])
+			start = i + 1
+		}
 	}
-	return str
+	return append(parts, str[start:])
 }
 
-// wrapPanicf recovers from a panic and then starts to panic with a message
-// that adds to the message of the previous panic. This function should always
-// be defered because of the recover and is commonly at the start of a
-// function.
-func wrapPanicf(format string, args ...interface{}) {
-	if r := recover(); r != nil {
-		msg := fmt.Sprintf(format, args...)
-		panic(fmt.Sprintf("%s:\n- %v", msg, r))
+func parseValue(str string) (Value, error) {
+	// An expression of the baseline is evaluated when the baseline is known.
+	if baselineRegex.MatchString(str) {
+		return parseBaselineExpr(str)
+	}
+
+	// The input is a number, a duration or an expression that evaluates to
+	// either.
+	v, err := Evaluate(str, nil)
+	if err != nil {
+		return nil, err
 	}
+	switch v := v.(type) {
+	case float64:
+		return Value(v), nil
+	case time.Duration:
+		return Value(v), nil
+	}
+
+	msg := fmt.Sprintf("value '%s' is not a number or duration", str)
+	return nil, errors.New(msg)
 }
@@ -0,0 +1,240 @@
+package main
+
+import "fmt"
+
+func Example_parseConfig() {
+	scns, err := parse([]byte(configTestYaml))
+	fmt.Println(err)
+	for _, scn := range scns {
+		c := scn.Config
+		fmt.Println(scn.Name, c.Binary, c.Args, c.BasePort, c.StatsAddr(), c.StatsPrefix, c.StableFor, c.StableTimeout)
+	}
+
+	_, err = parse([]byte(`
+config:
+  stats-port: 99999
+`))
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// default ./testpop [--listen=<LISTEN> --hosts=<HOSTS>] 4000 127.0.0.1:4300 ringpop 0s 1m0s
+	// override ./testpop [--listen=<LISTEN> --hosts=<HOSTS>] 4000 127.0.0.1:4300 ringpop 2s 1m0s
+	// line 3, column 15: config '99999': stats-port: 99999 is not a valid port
+}
+
+func Example_parseErrors() {
+	_, err := parse([]byte(errorsTestYaml))
+	for _, e := range err.(ParseErrors) {
+		fmt.Println(e)
+	}
+
+	_, err = parse([]byte("scenarios: [\n"))
+	fmt.Println(err)
+
+	// Output:
+	// line 6, column 5: scenario 'errors' run 1 script: command should contain exactly one entry
+	// line 8, column 9: scenario 'errors' run 1 script 't1: ': empty command
+	// line 10, column 5: scenario 'errors' run 1 measure 't0 t1': contains too few fields
+	// line 11, column 5: scenario 'errors' run 1 measure 't0 t1 count is 3 apples': assertion 'is 3 apples': eval error for expression: "3 apples"
+	// line 11, column 5: scenario 'errors' run 2 measure 't0 t1 count is 4 apples': assertion 'is 4 apples': eval error for expression: "4 apples"
+	// line 13, column 5: scenario 'errors' run 1 stable 'never': unknown stability criterion 'never'
+	// line 18, column 5: scenario 'errors' run 3 runs: var count of run [5 6] should match var count of [<N>]
+	// line 20, column 3: scenario 'no-size' run 1 size: scenario has no size
+	// line 1: did not find expected node content
+}
+
+var errorsTestYaml = `
+scenarios:
+- name: errors
+  size: <N>
+  script:
+  - t0: cluster-start
+    t1: cluster-kill
+  - t1: ""
+  measure:
+  - t0 t1
+  - t0 t1 count is <N> apples
+  stable:
+  - never
+  runs:
+  - [<N>]
+  - [3]
+  - [4]
+  - [5, 6]
+
+- name: no-size
+  runs:
+  - [<N>]
+  - [3]
+`
+
+var configTestYaml = `
+config:
+  binary: ./testpop
+  base-port: 4000
+  stats-port: 4300
+  stable-timeout: 1m
+
+scenarios:
+- name: default
+  size: 3
+  script:
+  - t0: cluster-start
+  runs:
+  - [<N>]
+  - [3]
+
+- name: override
+  size: 3
+  config:
+    stable-for: 2s
+  script:
+  - t0: cluster-start
+  runs:
+  - [<N>]
+  - [3]
+`
+
+func Example_parseMatrix() {
+	scns, err := parse([]byte(matrixTestYaml))
+	fmt.Println(err)
+	for _, scn := range scns {
+		fmt.Println(scn.Name, scn.Size, scn.Script[0].Args)
+	}
+
+	_, err = parse([]byte(`
+scenarios:
+- name: bad
+  size: <N>
+  matrix:
+    <N>: [3]
+    exclude:
+    - {<M>: 3}
+    include:
+    - {<N>: 4}
+  runs:
+  - [<N>]
+  - [3]
+- name: worse
+  size: <N>
+  matrix:
+    <N>: [3]
+    <DROP>: [1]
+    include:
+    - {<N>: 4}
+`))
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// drop[N=3,DROP=10%] 3 [0-1 10%]
+	// drop[N=3,DROP=50%] 3 [0-1 50%]
+	// drop[N=5,DROP=10%] 5 [0-2 10%]
+	// drop[N=7,DROP=0%] 7 [0-3 0%]
+	// line 3, column 3: scenario 'bad': scenario should declare either runs or a matrix
+	// line 20, column 7: scenario 'worse' matrix: include should assign a value to every variable, <DROP> is missing
+}
+
+var matrixTestYaml = `
+scenarios:
+- name: drop
+  size: <N>
+  script:
+  - t0: network-drop 0-{<N>/2-0.5} <DROP>
+  matrix:
+    <N>: [3, 5]
+    <DROP>: [10%, 50%]
+    exclude:
+    - {<N>: 5, <DROP>: 50%}
+    include:
+    - {<N>: 7, <DROP>: 0%}
+    - {<N>: 3, <DROP>: 10%}
+`
+
+func Example_parseRepeat() {
+	scns, err := parse([]byte(repeatTestYaml))
+	fmt.Println(err)
+	for _, cmd := range scns[0].Script {
+		fmt.Println(cmd.Label, cmd.Cmd, cmd.Args)
+	}
+	for _, m := range scns[0].Measure {
+		fmt.Println(m.Start, m.End, m.Iterations)
+	}
+
+	_, err = parse([]byte(`
+scenarios:
+- name: errors
+  size: 2
+  script:
+  - cycle:
+      repeat: 0
+      script:
+      - kill: kill 1
+  - loop:
+      script:
+      - kill: kill 1
+  measure:
+  - loop.*.kill loop.*.start convtime
+  runs:
+  - [<N>]
+  - [2]
+`))
+	fmt.Println(err)
+
+	// Output:
+	// <nil>
+	// t0 cluster-start []
+	// cycle.1.kill kill [1]
+	// cycle.1.start start [1]
+	// cycle.2.kill kill [1]
+	// cycle.2.start start [1]
+	// cycle.*.kill cycle.*.start 2
+	// cycle.*.kill cycle.*.start 2
+	// line 7, column 15: scenario 'errors' run 1 script '0': repeat count should be a positive number
+	// line 11, column 7: scenario 'errors' run 1 script 'loop': block should be a repeat block with a repeat count
+	// line 14, column 5: scenario 'errors' run 1 measure 'loop.*.kill loop.*.start convtime': labels loop.*.kill and loop.*.start match no iteration of a repeat block
+}
+
+func Example_parseValue() {
+	fmt.Println(parseValue("<N>*2"))
+	fmt.Println(parseValue("2*1s+500ms"))
+	fmt.Println(parseValue("1s+1"))
+	fmt.Println(parseRange("(0,5*200ms)"))
+	fmt.Println(parseRange("(1,2s)"))
+
+	// Output:
+	// <nil> eval error for expression: "<N>*2"
+	// 2.5s <nil>
+	// <nil> eval error for expression: "1s+1": operator + not defined on duration and number
+	// 0s 1s <nil>
+	// <nil> <nil> range types float64 time.Duration should be equal
+}
+
+func Example_parseMeasurement() {
+	for _, str := range []string{
+		"t0 t1 count ping.send <= 3*4",
+		"t0 t1 convtime in (0, 5*200ms)",
+		"t0 t1 convtime in (, max(1s, 2s))",
+		"t0 t1 checksums ≈ 10 ± 1",
+		"t0 t1 convtime within 15% of 2s",
+		"t0 t1 count suspect in (3, 3*3) and not 0",
+		"t0 t1 checksums (is 1 or > 5) and != 10",
+		"t0 t1 checksums is 1 or",
+		"t0 t1 checksums (is 1 or > 5",
+	} {
+		m, err := parseMeasurement(str)
+		fmt.Println(m, err)
+	}
+
+	// Output:
+	// count ping.send <= 12 <nil>
+	// convtime in (0s,1s) <nil>
+	// convtime in (,2s) <nil>
+	// checksums ≈ 10 ± 1 <nil>
+	// convtime within 15% of 2s <nil>
+	// count suspect in (3,9) and not is 0 <nil>
+	// checksums (is 1 or > 5) and != 10 <nil>
+	// <nil> assertion 'is 1 or': missing operand
+	// <nil> assertion '(is 1 or > 5': missing ')'
+}
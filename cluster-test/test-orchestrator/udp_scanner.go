@@ -2,17 +2,25 @@ package main
 
 import (
 	"net"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 // UDPScanner listens to a port for udp messages and converts them so that they
-// can be read through the Scanner interface.
+// can be read through the Scanner interface. Every line is prefixed with the
+// time it was received, e.g.
+// "2016-06-15T16:11:08.247032205Z|ringpop.127_0_0_1_3000.ping.send:1|c".
 type UDPScanner struct {
 	buf   []byte
 	text  string
 	err   error
 	sConn *net.UDPConn
+
+	// A single udp message can contain multiple newline separated stats.
+	// pending holds the stats of the last message that are not yet scanned.
+	pending []string
 }
 
 // NewUDPScanner starts listening on the specified port and returns a new
@@ -30,21 +38,31 @@ func NewUDPScanner(port string) (*UDPScanner, error) {
 	}
 
 	return &UDPScanner{
-		buf:   make([]byte, 1024),
+		buf:   make([]byte, 65536),
 		sConn: sConn,
 	}, nil
 }
 
 // Scans the next line, and returns whether there is one.
 func (s *UDPScanner) Scan() bool {
-	// read a single stat
-	n, err := s.sConn.Read(s.buf)
-	if err != nil {
-		s.err = errors.Wrap(err, "udp scan")
-		return false
+	for len(s.pending) == 0 {
+		// read a single message
+		n, err := s.sConn.Read(s.buf)
+		if err != nil {
+			s.err = errors.Wrap(err, "udp scan")
+			return false
+		}
+
+		ts := time.Now().UTC().Format(time.RFC3339Nano)
+		for _, line := range strings.Split(string(s.buf[0:n]), "\n") {
+			if line != "" {
+				s.pending = append(s.pending, ts+"|"+line)
+			}
+		}
 	}
 
-	s.text = string(s.buf[0:n])
+	s.text = s.pending[0]
+	s.pending = s.pending[1:]
 
 	return true
 }
@@ -58,3 +76,8 @@ func (s *UDPScanner) Text() string {
 func (s *UDPScanner) Err() error {
 	return s.err
 }
+
+// Close stops listening on the port. A Scan that is in progress returns false.
+func (s *UDPScanner) Close() error {
+	return s.sConn.Close()
+}